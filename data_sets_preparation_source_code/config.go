@@ -0,0 +1,218 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+	"gopkg.in/yaml.v3"
+)
+
+// A run described by a YAML or JSON file. The same structure is filled from the flags of the preparation commands, and its resolved form (with every default written out) is copied into the output directory.
+type preparationConfig struct {
+	Preparation               string                   `json:"preparation" yaml:"preparation"`
+	OutputDirectory           string                   `json:"output,omitempty" yaml:"output,omitempty"`
+	PrefixOfInputDownloadURLs string                   `json:"url_prefix,omitempty" yaml:"url_prefix,omitempty"`
+	InputDownloadURLs         []string                 `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+	Parameters                map[string]interface{}   `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Options                   preparationConfigOptions `json:"options" yaml:"options"`
+}
+
+// Resuming, forcing stages and the cache directory are about one run or one machine rather than about the data set, so they are only given as flags.
+type preparationConfigOptions struct {
+	MaximumConcurrentDownloads int               `json:"parallel_downloads,omitempty" yaml:"parallel_downloads,omitempty"`
+	Mirrors                    map[string]string `json:"mirrors,omitempty" yaml:"mirrors,omitempty"`
+	LocalFiles                 string            `json:"local_files,omitempty" yaml:"local_files,omitempty"`
+	Checksums                  map[string]string `json:"checksums,omitempty" yaml:"checksums,omitempty"`
+	UnsafeArchiveEntries       string            `json:"unsafe_archive_entries,omitempty" yaml:"unsafe_archive_entries,omitempty"`
+	MaximumUncompressedSize    int64             `json:"max_uncompressed_size,omitempty" yaml:"max_uncompressed_size,omitempty"`
+	MaximumArchiveEntries      int               `json:"max_archive_entries,omitempty" yaml:"max_archive_entries,omitempty"`
+	Umask                      string            `json:"umask,omitempty" yaml:"umask,omitempty"`
+	OutputFormat               string            `json:"output_format,omitempty" yaml:"output_format,omitempty"`
+}
+
+// Files ending in .json are read as JSON and all other files as YAML. Unknown fields are rejected so that a misspelt setting does not silently fall back to its default.
+func loadPreparationConfig(configFilePath string) (*preparationConfig, error) {
+	content, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return nil, &helpers.PreparationError{Stage: helpers.StageArguments, File: configFilePath, Err: err}
+	}
+
+	config := &preparationConfig{}
+	if strings.EqualFold(filepath.Ext(configFilePath), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(config)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		err = decoder.Decode(config)
+	}
+	if err != nil {
+		return nil, &helpers.PreparationError{Stage: helpers.StageArguments, File: configFilePath, Err: fmt.Errorf("invalid preparation config: %w", err)}
+	}
+	return config, nil
+}
+
+func (config *preparationConfig) setOptions(options helpers.PreparationOptions) {
+	config.Options = preparationConfigOptions{
+		MaximumConcurrentDownloads: options.MaximumConcurrentDownloads,
+		Mirrors:                    options.Mirrors,
+		LocalFiles:                 options.LocalFiles,
+		Checksums:                  options.Checksums,
+		UnsafeArchiveEntries:       options.UnsafeArchiveEntries,
+		MaximumUncompressedSize:    options.MaximumUncompressedSize,
+		MaximumArchiveEntries:      options.MaximumArchiveEntries,
+		Umask:                      options.Umask,
+		OutputFormat:               options.OutputFormat,
+	}
+}
+
+func (config *preparationConfig) preparationOptions() helpers.PreparationOptions {
+	return helpers.PreparationOptions{
+		MaximumConcurrentDownloads: config.Options.MaximumConcurrentDownloads,
+		Mirrors:                    config.Options.Mirrors,
+		LocalFiles:                 config.Options.LocalFiles,
+		Checksums:                  config.Options.Checksums,
+		UnsafeArchiveEntries:       config.Options.UnsafeArchiveEntries,
+		MaximumUncompressedSize:    config.Options.MaximumUncompressedSize,
+		MaximumArchiveEntries:      config.Options.MaximumArchiveEntries,
+		Umask:                      config.Options.Umask,
+		OutputFormat:               config.Options.OutputFormat,
+	}
+}
+
+// Parameter values are strings, numbers or lists in a config file. The items of lists are passed to the preparation as they are, while a string given for a list parameter is comma separated, as on the command line.
+func (config *preparationConfig) preparationParameters(preparation *helpers.RegisteredPreparation) (helpers.PreparationParameters, error) {
+	parameters := make(helpers.PreparationParameters)
+	for name, value := range config.Parameters {
+		parameter := preparation.Parameter(name)
+		isListParameter := parameter != nil && parameter.Type == helpers.ParameterTypeList
+		if list, isList := value.([]interface{}); isList {
+			if parameter != nil && !isListParameter {
+				return nil, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("invalid preparation config: parameter %q is not a list", name)}
+			}
+			items := make([]string, 0, len(list))
+			for _, item := range list {
+				items = append(items, fmt.Sprint(item))
+			}
+			parameters[name] = items
+		} else if list, isList := value.([]string); isList {
+			parameters[name] = append([]string(nil), list...)
+		} else if value == nil {
+			parameters[name] = nil
+		} else if _, isMap := value.(map[string]interface{}); isMap {
+			return nil, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("invalid preparation config: parameter %q is not a string, number or list", name)}
+		} else if isListParameter && fmt.Sprint(value) != "" {
+			parameters[name] = strings.Split(fmt.Sprint(value), ",")
+		} else {
+			parameters[name] = []string{fmt.Sprint(value)}
+		}
+	}
+	return parameters, nil
+}
+
+func (config *preparationConfig) setRequest(preparation *helpers.RegisteredPreparation, request *helpers.PreparationRequest) {
+	config.OutputDirectory = request.OutputDirectory
+	config.PrefixOfInputDownloadURLs = request.PrefixOfInputDownloadURLs
+	config.InputDownloadURLs = request.InputDownloadURLs
+	config.Parameters = make(map[string]interface{})
+	for _, parameter := range preparation.Parameters {
+		if parameter.Type == helpers.ParameterTypeList {
+			config.Parameters[parameter.Name] = request.Parameters.Strings(parameter.Name)
+		} else if parameter.Type == helpers.ParameterTypeInt {
+			config.Parameters[parameter.Name] = request.Parameters.Int(parameter.Name)
+		} else {
+			config.Parameters[parameter.Name] = request.Parameters.String(parameter.Name)
+		}
+	}
+}
+
+// Writes out every default, so that the resolved config does not depend on the defaults of a later version.
+func (config *preparationConfig) resolveOptions() {
+	if config.Options.MaximumConcurrentDownloads == 0 {
+		config.Options.MaximumConcurrentDownloads = helpers.DefaultMaximumConcurrentDownloads
+	}
+	if config.Options.LocalFiles == "" {
+		config.Options.LocalFiles = helpers.LocalFilesHardLink
+	}
+	if config.Options.UnsafeArchiveEntries == "" {
+		config.Options.UnsafeArchiveEntries = helpers.UnsafeArchiveEntriesReject
+	}
+	if config.Options.MaximumUncompressedSize == 0 {
+		config.Options.MaximumUncompressedSize = helpers.DefaultMaximumUncompressedSize
+	}
+	if config.Options.MaximumArchiveEntries == 0 {
+		config.Options.MaximumArchiveEntries = helpers.DefaultMaximumArchiveEntries
+	}
+	if config.Options.Umask == "" {
+		config.Options.Umask = helpers.DefaultUmask
+	}
+	if config.Options.OutputFormat == "" {
+		config.Options.OutputFormat = helpers.OutputFormatCSV
+	}
+}
+
+// Resolves the config against its registered preparation, writing out every default, and returns the request to run it with. The options that are not part of the config (resume, forced stages, dry run, log level and cache directory) are taken from runOptions.
+func resolvePreparationConfig(config *preparationConfig, runOptions helpers.PreparationOptions) (*helpers.RegisteredPreparation, *helpers.PreparationRequest, error) {
+	if config.Preparation == "" {
+		return nil, nil, &helpers.PreparationError{Stage: helpers.StageArguments, Err: errors.New("invalid preparation config: preparation type is missing")}
+	}
+	preparation, err := helpers.LookupPreparation(config.Preparation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parameters, err := config.preparationParameters(preparation)
+	if err != nil {
+		return nil, nil, err
+	}
+	request := &helpers.PreparationRequest{
+		OutputDirectory:           config.OutputDirectory,
+		PrefixOfInputDownloadURLs: config.PrefixOfInputDownloadURLs,
+		InputDownloadURLs:         config.InputDownloadURLs,
+		Parameters:                parameters,
+	}
+	err = preparation.Resolve(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.setRequest(preparation, request)
+	config.resolveOptions()
+
+	request.Options = config.preparationOptions()
+	request.Options.Resume = runOptions.Resume
+	request.Options.ForcedStages = runOptions.ForcedStages
+	request.Options.DryRun = runOptions.DryRun
+	request.Options.LogLevel = runOptions.LogLevel
+	request.Options.CacheDirectory = runOptions.CacheDirectory
+	request.Options.ResolvedConfig, err = json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, nil, &helpers.PreparationError{Stage: helpers.StageArguments, Err: err}
+	}
+	request.Options.ResolvedConfig = append(request.Options.ResolvedConfig, '\n')
+	return preparation, request, nil
+}
+
+// Resolves the config (see resolvePreparationConfig) and runs it.
+func runPreparationConfig(ctx context.Context, config *preparationConfig, runOptions helpers.PreparationOptions) error {
+	preparation, request, err := resolvePreparationConfig(config, runOptions)
+	if err != nil {
+		return err
+	}
+	return preparation.Prepare(ctx, request)
+}
@@ -0,0 +1,102 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+)
+
+func TestPreparationConfigRoundTrip(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         string
+		expectedLabels []string
+		expectError    bool
+	}{
+		{
+			name:           "list items with commas",
+			config:         "preparation: emails_features_1\noutput: out\nurl_prefix: http://localhost/\ninputs: [enron.tar.gz]\nparameters:\n  labels:\n    - sent\n    - 're:^maildir/[a-z]{1,3}/inbox$'\n  top_ranks: 20\n",
+			expectedLabels: []string{"sent", "re:^maildir/[a-z]{1,3}/inbox$"},
+		},
+		{
+			name:           "comma separated string",
+			config:         "preparation: emails_features_1\noutput: out\nurl_prefix: http://localhost/\ninputs: [enron.tar.gz]\nparameters:\n  labels: sent,inbox\n  top_ranks: 20\n",
+			expectedLabels: []string{"sent", "inbox"},
+		},
+		{
+			name:        "list of a parameter that is not a list",
+			config:      "preparation: emails_features_1\noutput: out\nurl_prefix: http://localhost/\ninputs: [enron.tar.gz]\nparameters:\n  labels: [sent, inbox]\n  top_ranks: [20, 30]\n",
+			expectError: true,
+		},
+		{
+			name:        "empty list item",
+			config:      "preparation: emails_features_1\noutput: out\nurl_prefix: http://localhost/\ninputs: [enron.tar.gz]\nparameters:\n  labels: [sent, '']\n",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			configFilePath := filepath.Join(directory, "config.yaml")
+			err := os.WriteFile(configFilePath, []byte(test.config), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := loadPreparationConfig(configFilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, request, err := resolvePreparationConfig(config, helpers.PreparationOptions{})
+			if test.expectError {
+				var preparationError *helpers.PreparationError
+				if !errors.As(err, &preparationError) || preparationError.Stage != helpers.StageArguments {
+					t.Fatalf("expected an arguments error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(request.Parameters.Strings("labels"), test.expectedLabels) {
+				t.Errorf("labels = %q, expected %q", request.Parameters.Strings("labels"), test.expectedLabels)
+			}
+			if request.Parameters.Int("top_ranks") != 20 || request.Parameters.String("source") != "enron" {
+				t.Errorf("top_ranks = %d and source = %q, expected 20 and the default enron", request.Parameters.Int("top_ranks"), request.Parameters.String("source"))
+			}
+
+			resolvedConfigFilePath := filepath.Join(directory, helpers.ResolvedConfigFileName)
+			err = os.WriteFile(resolvedConfigFilePath, request.Options.ResolvedConfig, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resolvedConfig, err := loadPreparationConfig(resolvedConfigFilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, resolvedRequest, err := resolvePreparationConfig(resolvedConfig, helpers.PreparationOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resolvedRequest.Parameters, request.Parameters) {
+				t.Errorf("parameters of the resolved config = %q, expected %q", resolvedRequest.Parameters, request.Parameters)
+			}
+			if string(resolvedRequest.Options.ResolvedConfig) != string(request.Options.ResolvedConfig) {
+				t.Errorf("resolving the resolved config gave\n%s\nexpected\n%s", resolvedRequest.Options.ResolvedConfig, request.Options.ResolvedConfig)
+			}
+		})
+	}
+}
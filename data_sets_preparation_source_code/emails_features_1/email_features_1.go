@@ -6,17 +6,24 @@
 	The purpose of writing this code is academic.
 */
 
+// Per-email word features of labelled emails (e.g. the Enron corpus).
+//
+// SelectEmails picks the emails of the labels from any fs.FS and ComputeFeatures computes their features in memory, which WriteEmails and WriteFile write to disk.
 package emails_features_1
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	pq "github.com/emirpasic/gods/queues/priorityqueue"
 	"github.com/emirpasic/gods/utils"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"math"
 	"math/rand"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -26,195 +33,520 @@ import (
 import helpers "github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
 
 type EmailFeaturesPreparation struct {
+	Options Options
 }
 
-func (emailFeaturesPreparation EmailFeaturesPreparation) Prepare(dataSetPreparationInformation *helpers.DataSetPreparationInformation, outputDirectory string) {
-	fmt.Println()
-	fmt.Println("Note: all numbers reported may be subject to rounding or truncation rounding. Assumption of exact value should not be made without looking at the source code.")
-	fmt.Println()
+func (emailFeaturesPreparation EmailFeaturesPreparation) Plan(dataSetPreparationInformation *helpers.DataSetPreparationInformation, outputDirectory string) helpers.PreparationPlan {
+	return helpers.PreparationPlan{FinalFiles: []string{filepath.Join("Final_files", helpers.OutputFileName("emails_features", dataSetPreparationInformation.Options.OutputFormat))}}
+}
 
-	selectAndCopyEmails(dataSetPreparationInformation, outputDirectory)
+func (emailFeaturesPreparation EmailFeaturesPreparation) Prepare(ctx context.Context, dataSetPreparationInformation *helpers.DataSetPreparationInformation, outputDirectory string) error {
+	helpers.Log.EmptyLine()
+	helpers.Log.Info(helpers.EventMessage, nil, "Note: all numbers reported may be subject to rounding or truncation rounding. Assumption of exact value should not be made without looking at the source code.")
+	helpers.Log.EmptyLine()
 
 	emailsDirectory := filepath.Join(outputDirectory, "Temporary_files", "emails")
+	err := os.RemoveAll(emailsDirectory)
+	if err != nil {
+		return &helpers.PreparationError{Stage: "selecting emails", File: emailsDirectory, Err: err}
+	}
 
-	numberOfEmails,
-		initialParsedWords,
-		emailsDirectoryNumbers,
-		insideEmailWordFreqNormalized,
-		numberOfEmailsContainingWord := parseFilteredEmailsDirectoryAndCalculateInitialWordStats(emailsDirectory)
+	options := emailFeaturesPreparation.Options
+	options.Log = helpers.Log
 
-	if numberOfEmails != len(emailsDirectoryNumbers) {
-		panic("Not finished successfully.")
+	uncompressedDirectory := filepath.Join(outputDirectory, "Uncompressed_downloaded_files")
+	selection, err := SelectEmails(ctx, os.DirFS(uncompressedDirectory), options)
+	if err != nil {
+		// The files of the errors are relative to the uncompressed directory.
+		var preparationError *helpers.PreparationError
+		if errors.As(err, &preparationError) && preparationError.File != "" {
+			preparationError.File = filepath.Join(uncompressedDirectory, filepath.FromSlash(preparationError.File))
+		}
+		return err
 	}
 
-	fmt.Println("Number of initial parsed words:", len(initialParsedWords))
-	stopWordsFilteredWords := filterStopWords(initialParsedWords)
-	fmt.Println("Number of stop words filtered words:", len(stopWordsFilteredWords))
-	basicFilteredWords := filterWordsLexical(stopWordsFilteredWords)
-	fmt.Println("Number of basic filtered words:", len(basicFilteredWords))
+	err = selection.WriteEmails(emailsDirectory)
+	if err != nil {
+		return err
+	}
 
-	perEmailSignificanceForBasicFilteredWords := computePerEmailSignificanceForBasicFilteredWords(numberOfEmails, basicFilteredWords, insideEmailWordFreqNormalized, numberOfEmailsContainingWord)
-	perEmailSignificanceRanksForBasicFilteredWords := computePerEmailSignificanceRanksForBasicFilteredWords(numberOfEmails, perEmailSignificanceForBasicFilteredWords)
-	fmt.Println("Significance and significance ranks for basic filtered words calculated.")
+	result, err := ComputeFeatures(ctx, selection, options)
+	if err != nil {
+		return err
+	}
 
-	firstFreqFilteredWords := filterWordsWithLowNumberOfEmailsContainingWord(basicFilteredWords, numberOfEmailsContainingWord)
-	fmt.Println("Number of first frequency filtered words", len(firstFreqFilteredWords))
+	err = result.WriteFile(filepath.Join(outputDirectory, "Final_files", helpers.OutputFileName("emails_features", dataSetPreparationInformation.Options.OutputFormat)))
+	if err != nil {
+		return err
+	}
 
-	secondFreqFilteredWords := filterWordsWithLowNumberOfOccurrencesInPerEmailHighRankingWords(numberOfEmails, basicFilteredWords, firstFreqFilteredWords, perEmailSignificanceRanksForBasicFilteredWords)
-	sort.Strings(secondFreqFilteredWords)
-	fmt.Println("Number of second frequency filtered words", len(secondFreqFilteredWords))
+	_, err = result.EvaluateKnn(ctx, 10)
+	return err
+}
 
-	perEmailSignificanceForSecondFreqFilteredWords,
-		perEmailSignificanceRanksForSecondFreqFilteredWords := extractPerEmailSignificanceAndSignificanceRanksForSecondFreqFilteredWords(numberOfEmails, basicFilteredWords, secondFreqFilteredWords, perEmailSignificanceForBasicFilteredWords, perEmailSignificanceRanksForBasicFilteredWords)
-	fmt.Println("Significance and significance ranks for second frequency filtered words extracted.")
-	fmt.Println()
+// Options of SelectEmails, ComputeFeatures and Compute.
+type Options struct {
+	// Mailbox names, globs or regular expressions, optionally merged into classes (see parseLabels). The first class has the label number 0.
+	Labels []string
 
-	perEmailCosineTailoredFeatures := computePerEmailCosineTailoredFeatures(numberOfEmails, secondFreqFilteredWords, perEmailSignificanceForSecondFreqFilteredWords, perEmailSignificanceRanksForSecondFreqFilteredWords, emailsDirectoryNumbers)
-	perEmailCosineTailoredFeaturesAndDirectoryNumber := combineFeaturesWithEmailDirectoryNumber(perEmailCosineTailoredFeatures, emailsDirectoryNumbers)
-	scrambleTheSortingOfEmailsAndWriteToFile(numberOfEmails, perEmailCosineTailoredFeaturesAndDirectoryNumber, filepath.Join(outputDirectory, "Final_files", "emails_features.csv"))
-	computeKnnClassificationAccuracy(perEmailCosineTailoredFeaturesAndDirectoryNumber, 10)
+	// EnronDirectories if nil, MaildirFolders, MboxFiles, EmlDirectories or any other EmailSource.
+	Source EmailSource
+
+	// How emails are parsed: ParserEnron (if empty) or ParserMime.
+	Parser string
+
+	Thresholds Thresholds
+
+	Sampling Sampling
+
+	// Where the progress and the statistics are logged, nothing being logged if nil. The preparation logs to helpers.Log.
+	Log *helpers.Logger
 }
 
-func selectAndCopyEmails(dataSetPreparationInformation *helpers.DataSetPreparationInformation, outputDirectory string) {
-	labelNumbers := make(map[string]int)
+// An email of a Selection. The path is in the file system the email was selected from.
+type Email struct {
+	Path    string
+	Label   int
+	Content []byte
+}
 
-	for _, parameter := range dataSetPreparationInformation.Parameters {
-		labelNumbers[parameter] = len(labelNumbers) + 1
+// The emails of the labels, in the order their features are computed in.
+type Selection struct {
+	LabelNames []string
+
+	// The paths of the mailboxes the emails of each label were selected from.
+	LabelMailboxes [][]string
+
+	Emails     []Email
+	Statistics helpers.Statistics
+}
+
+// The features of the emails, with the rows in the (shuffled) order of emails_features.csv.
+type Result struct {
+	LabelNames []string
+	EmailPaths []string
+	Labels     []int
+
+	// A row per email: a 0 or 1 per word of the vocabulary, followed by the secondary features (each 1 for a single email only).
+	Features [][]uint8
+
+	// The words of the primary features, sorted.
+	Vocabulary []string
+
+	Statistics helpers.Statistics
+
+	// The logger of the options of ComputeFeatures, which EvaluateKnn logs to.
+	log *helpers.Logger
+}
+
+// The accuracy of majority voting of the K nearest neighbours (by cosine distance) of each email.
+type KnnEvaluation struct {
+	K               int
+	AccuracyPercent float64
+
+	// Percentages of all emails, by actual and then predicted label number.
+	ConfusionMatrixPercent [][]float64
+
+	Statistics helpers.Statistics
+}
+
+// Selects the emails of the labels and computes their features.
+func Compute(ctx context.Context, corpus fs.FS, options Options) (*Result, error) {
+	selection, err := SelectEmails(ctx, corpus, options)
+	if err != nil {
+		return nil, err
 	}
 
-	minimumEmailsCount := 300
-	maximumEmailsCount := 300
+	result, err := ComputeFeatures(ctx, selection, options)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Statistics = append(append(helpers.Statistics{}, selection.Statistics...), result.Statistics...)
+	return result, nil
+}
+
+// Selects up to MaximumEmailsPerLabel emails of each label class from the mailboxes its labels match, as the Sampling options say.
+// The emails are ordered by the directory names of their label numbers and then by file name, both sorted as text.
+func SelectEmails(ctx context.Context, corpus fs.FS, options Options) (*Selection, error) {
+	classes, err := parseLabels(options.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	thresholds, err := options.Thresholds.WithDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	sampling, err := options.Sampling.WithDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	source := options.Source
+	if source == nil {
+		source = EnronDirectories{}
+	}
 
-	firstDirectory := make(map[string]string)
-	directorySelectedEmailsCount := make(map[string]int)
+	mailboxes, err := source.Mailboxes(ctx, corpus)
+	if err != nil {
+		return nil, err
+	}
+
+	classMailboxes, err := matchClassMailboxes(classes, mailboxes, thresholds.MinimumEmailsPerLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	selection := &Selection{Emails: []Email{}}
 	totalSelectedEmailsCount := 0
+	classSelectedEmailsCounts := make([][]int, len(classes))
+
+	for classNumber, class := range classes {
+		selection.LabelNames = append(selection.LabelNames, class.name)
+		emailsCounts := make([]int, len(classMailboxes[classNumber]))
+		classEmailsCount := 0
+		mailboxPaths := []string{}
+		for i, mailbox := range classMailboxes[classNumber] {
+			emailsCounts[i] = mailbox.emailsCount
+			classEmailsCount += mailbox.emailsCount
+			mailboxPaths = append(mailboxPaths, mailbox.Path())
+		}
+		selection.LabelMailboxes = append(selection.LabelMailboxes, mailboxPaths)
 
-	filepath.Walk(filepath.Join(outputDirectory, "Uncompressed_downloaded_files"), func(path string, info fs.FileInfo, err error) error {
-		if !info.IsDir() {
-			directory := filepath.Base(filepath.Dir(path))
-			labelNumber := labelNumbers[directory] - 1
-			if labelNumber == -1 {
-				return nil
+		if len(class.patterns) == 1 && class.patterns[0].isMailboxName() && len(mailboxPaths) == 0 {
+			return nil, &helpers.PreparationError{Stage: "selecting emails", File: ".", Err: fmt.Errorf("no mailbox named %q with at least %d emails found", class.patterns[0].name, thresholds.MinimumEmailsPerLabel)}
+		}
+		if len(mailboxPaths) == 0 {
+			return nil, &helpers.PreparationError{Stage: "selecting emails", File: ".", Err: fmt.Errorf("no mailbox with emails matches the labels of class %q", class.name)}
+		}
+		if classEmailsCount < thresholds.MinimumEmailsPerLabel {
+			return nil, &helpers.PreparationError{Stage: "selecting emails", File: ".", Err: fmt.Errorf("the mailboxes of label class %q (%s) have %d emails, fewer than %d", class.name, strings.Join(mailboxPaths, ", "), classEmailsCount, thresholds.MinimumEmailsPerLabel)}
+		}
+
+		maximumEmailsCount := thresholds.MaximumEmailsPerLabel
+		if maximumEmailsCount > classEmailsCount {
+			maximumEmailsCount = classEmailsCount
+		}
+		classSelectedEmailsCounts[classNumber] = sampling.quotas(emailsCounts, maximumEmailsCount)
+
+		for i, mailbox := range classMailboxes[classNumber] {
+			mailboxSelectedEmailsCount := classSelectedEmailsCounts[classNumber][i]
+			if mailboxSelectedEmailsCount == 0 {
+				continue
 			}
 
-			if firstDirectory[directory] == "" {
-				filePaths, _ := filepath.Glob(filepath.Dir(path) + "/*")
-				emailsCount := 0
-				for _, filePath := range filePaths {
-					stat, _ := os.Stat(filePath)
-					if !stat.IsDir() {
-						emailsCount++
-					}
+			isSampled := sampling.sampledEmails(mailbox.Path(), mailbox.emailsCount, mailboxSelectedEmailsCount)
+			isSelected := func(emailNumber int) bool {
+				return emailNumber < len(isSampled) && isSampled[emailNumber]
+			}
+			selectedEmailsCount := 0
+			err = mailbox.ReadEmails(ctx, isSelected, func(email Email) error {
+				email.Label = classNumber
+				selection.Emails = append(selection.Emails, email)
+
+				selectedEmailsCount++
+				totalSelectedEmailsCount++
+
+				if totalSelectedEmailsCount%100 == 0 {
+					options.Log.Info("emails_selection_progress", helpers.LogFields{"number_of_emails_selected": totalSelectedEmailsCount}, "Current number of selected emails:", totalSelectedEmailsCount)
 				}
 
-				if emailsCount < minimumEmailsCount {
-					return nil
+				if selectedEmailsCount >= mailboxSelectedEmailsCount {
+					return errEnoughEmails
 				}
-				firstDirectory[directory] = filepath.Dir(path)
-			} else if firstDirectory[directory] != filepath.Dir(path) {
 				return nil
+			})
+			if err != nil && err != errEnoughEmails {
+				return nil, err
 			}
+		}
+	}
 
-			copyDirectory := filepath.Join(outputDirectory, "Temporary_files", "emails", strconv.Itoa(labelNumber))
-			_, err = os.Stat(copyDirectory)
-			if os.IsNotExist(err) {
-				os.MkdirAll(copyDirectory, 600)
+	selection.Statistics.Add(options.Log, "total_number_of_emails_selected", totalSelectedEmailsCount, nil, "Total number of emails selected:", totalSelectedEmailsCount)
+	options.Log.Info(helpers.EventMessage, nil, "Directories, directory numbers and number of emails selected:")
+	for classNumber, class := range classes {
+		classSelectedEmailsCount := 0
+		for _, mailboxSelectedEmailsCount := range classSelectedEmailsCounts[classNumber] {
+			classSelectedEmailsCount += mailboxSelectedEmailsCount
+		}
+		relativeDirectory := strings.Join(selection.LabelMailboxes[classNumber], ", ")
+		selection.Statistics.Add(options.Log, "number_of_emails_selected_of_directory", classSelectedEmailsCount,
+			helpers.LogFields{"directory": class.name, "relative_directory": relativeDirectory, "directory_number": classNumber},
+			"\t", class.name, "(", relativeDirectory, "):", "(Number:", classNumber, ") , (Number of emails:", classSelectedEmailsCount, ")")
+
+		if len(classMailboxes[classNumber]) > 1 {
+			for i, mailbox := range classMailboxes[classNumber] {
+				selection.Statistics.Add(options.Log, "number_of_emails_selected_of_mailbox", classSelectedEmailsCounts[classNumber][i],
+					helpers.LogFields{"directory": class.name, "relative_directory": mailbox.Path(), "directory_number": classNumber, "number_of_emails": mailbox.emailsCount},
+					"\t\t", mailbox.Path(), ": (Number of emails:", classSelectedEmailsCounts[classNumber][i], "of", mailbox.emailsCount, ")")
 			}
+		}
+	}
 
-			if directorySelectedEmailsCount[directory] >= maximumEmailsCount {
-				return nil
-			}
+	sort.SliceStable(selection.Emails, func(i, j int) bool {
+		labelDirectoryI := strconv.Itoa(selection.Emails[i].Label)
+		labelDirectoryJ := strconv.Itoa(selection.Emails[j].Label)
+		if labelDirectoryI != labelDirectoryJ {
+			return labelDirectoryI < labelDirectoryJ
+		}
+		if path.Base(selection.Emails[i].Path) != path.Base(selection.Emails[j].Path) {
+			return path.Base(selection.Emails[i].Path) < path.Base(selection.Emails[j].Path)
+		}
+		return selection.Emails[i].Path < selection.Emails[j].Path
+	})
 
-			copyPath := filepath.Join(copyDirectory, filepath.Base(path))
+	return selection, nil
+}
 
-			bytes, err := ioutil.ReadFile(path)
-			if err != nil {
-				panic("Not finished successfully.")
-			}
+type countedMailbox struct {
+	Mailbox
+	emailsCount int
+}
 
-			err = ioutil.WriteFile(copyPath, bytes, 600)
+// A mailbox name pattern adds the first mailbox with that name and at least minimumEmailsCount emails, and the other patterns every matching mailbox.
+func matchClassMailboxes(classes []labelClass, mailboxes []Mailbox, minimumEmailsCount int) ([][]countedMailbox, error) {
+	emailsCounts := make([]int, len(mailboxes))
+	for i := range emailsCounts {
+		emailsCounts[i] = -1
+	}
+	emailsCount := func(mailboxNumber int) (int, error) {
+		if emailsCounts[mailboxNumber] == -1 {
+			count, err := mailboxes[mailboxNumber].NumberOfEmails()
 			if err != nil {
-				panic("Not finished successfully.")
+				return 0, err
 			}
+			emailsCounts[mailboxNumber] = count
+		}
+		return emailsCounts[mailboxNumber], nil
+	}
 
-			directorySelectedEmailsCount[directory]++
-			totalSelectedEmailsCount++
+	mailboxClasses := make(map[int]int)
+	classMailboxNumbers := make([][]int, len(classes))
+	for classNumber, class := range classes {
+		for _, pattern := range class.patterns {
+			for mailboxNumber, mailbox := range mailboxes {
+				if !pattern.matches(mailbox) {
+					continue
+				}
+				count, err := emailsCount(mailboxNumber)
+				if err != nil {
+					return nil, err
+				}
+				if count == 0 || pattern.isMailboxName() && count < minimumEmailsCount {
+					continue
+				}
 
-			if totalSelectedEmailsCount%100 == 0 {
-				fmt.Println("Current number of selected emails:", totalSelectedEmailsCount)
+				matchingClassNumber, isMatched := mailboxClasses[mailboxNumber]
+				if isMatched && matchingClassNumber != classNumber {
+					return nil, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("mailbox %q matches the labels of both classes %q and %q", mailbox.Path(), classes[matchingClassNumber].name, class.name)}
+				}
+				if !isMatched {
+					mailboxClasses[mailboxNumber] = classNumber
+					classMailboxNumbers[classNumber] = append(classMailboxNumbers[classNumber], mailboxNumber)
+				}
+				if pattern.isMailboxName() {
+					break
+				}
 			}
 		}
-		return nil
-	})
+	}
 
-	fmt.Println("Total number of emails selected:", totalSelectedEmailsCount)
-	fmt.Println("Directories, directory numbers and number of emails selected:")
-	for directory, directoryNumber := range labelNumbers {
-		relativeDirectory := strings.TrimPrefix(firstDirectory[directory], filepath.Join(outputDirectory, "Uncompressed_downloaded_files"))
-		relativeDirectory = strings.ReplaceAll(relativeDirectory, "\\", "/")
-		relativeDirectory = strings.TrimPrefix(relativeDirectory, "/")
-		fmt.Println("\t", directory, "(", relativeDirectory, "):", "(Number:", directoryNumber-1, ") , (Number of emails:", directorySelectedEmailsCount[directory], ")")
+	classMailboxes := make([][]countedMailbox, len(classes))
+	for classNumber, mailboxNumbers := range classMailboxNumbers {
+		sort.Ints(mailboxNumbers)
+		classMailboxes[classNumber] = []countedMailbox{}
+		for _, mailboxNumber := range mailboxNumbers {
+			classMailboxes[classNumber] = append(classMailboxes[classNumber], countedMailbox{Mailbox: mailboxes[mailboxNumber], emailsCount: emailsCounts[mailboxNumber]})
+		}
 	}
+	return classMailboxes, nil
 }
 
-func parseFilteredEmailsDirectoryAndCalculateInitialWordStats(emailsDirectory string) (
-	numberOfEmails int,
-	words []string,
-	emailsDirectoryNumber []int,
-	insideEmailWordFreqNormalized []map[string]float64,
-	numberOfEmailContainingWord map[string]int) {
+var errEnoughEmails = errors.New("enough emails selected")
 
-	emailsDirectoryNumber = make([]int, 0)
-	insideEmailWordFreqNormalized = make([]map[string]float64, 0)
-	numberOfEmailContainingWord = make(map[string]int)
-	words = make([]string, 0)
-
-	numberOfEmails = 0
+// Writes each email to <directory>/<label number>/<file name>, or <path with _ for /> if file names of a label collide.
+func (selection *Selection) WriteEmails(directory string) error {
+	fileNameCounts := make(map[string]int)
+	for _, email := range selection.Emails {
+		fileNameCounts[strconv.Itoa(email.Label)+"/"+path.Base(email.Path)]++
+	}
 
-	filepath.Walk(emailsDirectory, func(path string, info fs.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
+	for _, email := range selection.Emails {
+		copyDirectory := filepath.Join(directory, strconv.Itoa(email.Label))
+		err := os.MkdirAll(copyDirectory, helpers.DirectoryMode())
+		if err != nil {
+			return &helpers.PreparationError{Stage: "selecting emails", File: copyDirectory, Err: err}
 		}
 
-		directoryNumber, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		fileName := path.Base(email.Path)
+		if fileNameCounts[strconv.Itoa(email.Label)+"/"+fileName] > 1 {
+			fileName = strings.ReplaceAll(email.Path, "/", "_")
+		}
+		copyPath := filepath.Join(copyDirectory, fileName)
+		err = ioutil.WriteFile(copyPath, email.Content, helpers.FileMode())
 		if err != nil {
-			panic("Not finished successfully.")
+			return &helpers.PreparationError{Stage: "selecting emails", File: copyPath, Err: err}
 		}
+	}
 
-		bytes, err := ioutil.ReadFile(path)
-		if err != nil {
-			panic("Not finished successfully.")
+	return nil
+}
+
+// Computes the features of the emails of the selection, in its order.
+func ComputeFeatures(ctx context.Context, selection *Selection, options Options) (*Result, error) {
+	thresholds, err := options.Thresholds.WithDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	numberOfEmails := len(selection.Emails)
+	if numberOfEmails == 0 {
+		return nil, &helpers.PreparationError{Stage: "parsing emails", Err: errors.New("no emails were selected")}
+	}
+
+	emailsDirectoryNumbers := make([]int, numberOfEmails)
+	for emailNumber, email := range selection.Emails {
+		emailsDirectoryNumbers[emailNumber] = email.Label
+	}
+
+	initialParsedWords,
+		insideEmailWordFreqNormalized,
+		numberOfEmailsContainingWord,
+		err := parseEmailsAndCalculateInitialWordStats(ctx, selection.Emails, options.Parser, options.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{LabelNames: selection.LabelNames, log: options.Log}
+
+	result.Statistics.Add(options.Log, "number_of_initial_parsed_words", len(initialParsedWords), nil, "Number of initial parsed words:", len(initialParsedWords))
+	stopWordsFilteredWords := filterStopWords(initialParsedWords)
+	result.Statistics.Add(options.Log, "number_of_stop_words_filtered_words", len(stopWordsFilteredWords), nil, "Number of stop words filtered words:", len(stopWordsFilteredWords))
+	basicFilteredWords := filterWordsLexical(stopWordsFilteredWords)
+	result.Statistics.Add(options.Log, "number_of_basic_filtered_words", len(basicFilteredWords), nil, "Number of basic filtered words:", len(basicFilteredWords))
+
+	perEmailSignificanceForBasicFilteredWords := computePerEmailSignificanceForBasicFilteredWords(numberOfEmails, basicFilteredWords, insideEmailWordFreqNormalized, numberOfEmailsContainingWord)
+	perEmailSignificanceRanksForBasicFilteredWords := computePerEmailSignificanceRanksForBasicFilteredWords(numberOfEmails, perEmailSignificanceForBasicFilteredWords)
+	options.Log.Info(helpers.EventMessage, nil, "Significance and significance ranks for basic filtered words calculated.")
+
+	firstFreqFilteredWords := filterWordsWithLowNumberOfEmailsContainingWord(basicFilteredWords, numberOfEmailsContainingWord, thresholds.MinimumEmailsContainingWord)
+	result.Statistics.Add(options.Log, "number_of_first_frequency_filtered_words", len(firstFreqFilteredWords), nil, "Number of first frequency filtered words", len(firstFreqFilteredWords))
+
+	secondFreqFilteredWords := filterWordsWithLowNumberOfOccurrencesInPerEmailHighRankingWords(numberOfEmails, basicFilteredWords, firstFreqFilteredWords, perEmailSignificanceRanksForBasicFilteredWords, thresholds)
+	sort.Strings(secondFreqFilteredWords)
+	result.Statistics.Add(options.Log, "number_of_second_frequency_filtered_words", len(secondFreqFilteredWords), nil, "Number of second frequency filtered words", len(secondFreqFilteredWords))
+
+	perEmailSignificanceForSecondFreqFilteredWords,
+		perEmailSignificanceRanksForSecondFreqFilteredWords := extractPerEmailSignificanceAndSignificanceRanksForSecondFreqFilteredWords(numberOfEmails, basicFilteredWords, secondFreqFilteredWords, perEmailSignificanceForBasicFilteredWords, perEmailSignificanceRanksForBasicFilteredWords, thresholds.TopRanks)
+	options.Log.Info(helpers.EventMessage, nil, "Significance and significance ranks for second frequency filtered words extracted.")
+	options.Log.EmptyLine()
+
+	if ctx.Err() != nil {
+		return nil, &helpers.PreparationError{Stage: "computing features", Err: ctx.Err()}
+	}
+
+	perEmailCosineTailoredFeatures, err := computePerEmailCosineTailoredFeatures(numberOfEmails, secondFreqFilteredWords, perEmailSignificanceForSecondFreqFilteredWords, perEmailSignificanceRanksForSecondFreqFilteredWords, emailsDirectoryNumbers, thresholds.MaximumSecondaryFeatures, &result.Statistics, options.Log)
+	if err != nil {
+		return nil, err
+	}
+	perEmailCosineTailoredFeaturesAndDirectoryNumber := combineFeaturesWithEmailDirectoryNumber(perEmailCosineTailoredFeatures, emailsDirectoryNumbers)
+
+	emailPaths := make([]string, numberOfEmails)
+	for emailNumber, email := range selection.Emails {
+		emailPaths[emailNumber] = email.Path
+	}
+	scrambleTheSortingOfEmails(perEmailCosineTailoredFeaturesAndDirectoryNumber, emailPaths)
+
+	result.EmailPaths = emailPaths
+	result.Labels = make([]int, numberOfEmails)
+	result.Features = make([][]uint8, numberOfEmails)
+	for emailNumber, directoryNumberAndFeatures := range perEmailCosineTailoredFeaturesAndDirectoryNumber {
+		result.Labels[emailNumber] = int(directoryNumberAndFeatures[0])
+		result.Features[emailNumber] = directoryNumberAndFeatures[1:]
+	}
+	result.Vocabulary = secondFreqFilteredWords
+
+	return result, nil
+}
+
+// Writes the rows as lines of the label number followed by the features, comma separated.
+func (result *Result) WriteCSV(writer io.Writer) error {
+	return result.WriteTable(writer, ",")
+}
+
+// Like WriteCSV, with another separator.
+func (result *Result) WriteTable(writer io.Writer, separator string) error {
+	csv := strings.Builder{}
+
+	for emailNumber := range result.Features {
+		csv.WriteString(strconv.Itoa(result.Labels[emailNumber]))
+		for _, feature := range result.Features[emailNumber] {
+			csv.WriteString(separator)
+			csv.WriteString(strconv.Itoa(int(feature)))
 		}
+		csv.WriteString("\r\n")
+	}
 
-		numberOfEmails++
+	_, err := io.WriteString(writer, csv.String())
+	return err
+}
 
-		lines := strings.Split(strings.ToLower(string(bytes)), "\n")
+// Tab separated for .tsv files and comma separated otherwise.
+func (result *Result) WriteFile(outputFilePath string) error {
+	csv := strings.Builder{}
+	err := result.WriteTable(&csv, helpers.OutputSeparatorOf(outputFilePath))
+	if err != nil {
+		return &helpers.PreparationError{Stage: helpers.StageWritingFile, File: outputFilePath, Err: err}
+	}
 
-		emailsDirectoryNumber = append(emailsDirectoryNumber, directoryNumber)
-		emailWordFreq := make(map[string]int)
-		emailWordFreqNormalized := make(map[string]float64)
-		insideEmailWordFreqNormalized = append(insideEmailWordFreqNormalized, emailWordFreqNormalized)
-		emailWordFreqSum := 0
+	err = ioutil.WriteFile(outputFilePath, []byte(csv.String()), helpers.FileMode())
+	if err != nil {
+		return &helpers.PreparationError{Stage: helpers.StageWritingFile, File: outputFilePath, Err: err}
+	}
 
-		trimLineNumber := -1
-		subjectLine := []string{""}
-		for lineNumber, line := range lines {
-			line = strings.TrimSpace(line)
-			if strings.HasPrefix(line, "x-filename:") {
-				trimLineNumber = lineNumber
-			}
+	return nil
+}
 
-			if strings.HasPrefix(line, "subject:") && trimLineNumber == -1 {
-				subjectLine[0] = line
-			}
+// Computes the KNN majority voting classification accuracy of the rows, each email being classified by the other ones.
+func (result *Result) EvaluateKnn(ctx context.Context, k int) (*KnnEvaluation, error) {
+	if len(result.Features) == 0 {
+		return nil, &helpers.PreparationError{Stage: "computing KNN accuracy", Err: errors.New("there are no emails")}
+	}
+
+	directoryNumbersAndFeatures := make([][]uint8, len(result.Features))
+	for emailNumber, features := range result.Features {
+		directoryNumbersAndFeatures[emailNumber] = append([]uint8{uint8(result.Labels[emailNumber])}, features...)
+	}
+
+	return computeKnnClassificationAccuracy(ctx, directoryNumbersAndFeatures, k, result.log)
+}
+
+func parseEmailsAndCalculateInitialWordStats(ctx context.Context, emails []Email, parser string, logger *helpers.Logger) (
+	words []string,
+	insideEmailWordFreqNormalized []map[string]float64,
+	numberOfEmailContainingWord map[string]int,
+	err error) {
+
+	insideEmailWordFreqNormalized = make([]map[string]float64, 0, len(emails))
+	numberOfEmailContainingWord = make(map[string]int)
+	words = make([]string, 0)
+
+	for _, email := range emails {
+		if ctx.Err() != nil {
+			return nil, nil, nil, &helpers.PreparationError{Stage: "parsing emails", Err: ctx.Err()}
 		}
 
-		if trimLineNumber == -1 {
-			panic("Not finished successfully.")
+		lines, err := emailLines(email, parser, logger)
+		if err != nil {
+			return nil, nil, nil, err
 		}
 
-		lines = lines[trimLineNumber+1:]
-		lines = append(subjectLine, lines...)
+		emailWordFreq := make(map[string]int)
+		emailWordFreqNormalized := make(map[string]float64)
+		insideEmailWordFreqNormalized = append(insideEmailWordFreqNormalized, emailWordFreqNormalized)
+		emailWordFreqSum := 0
 
 		for _, line := range lines {
 			if strings.HasPrefix(strings.TrimSpace(line), "---") {
@@ -256,13 +588,11 @@ func parseFilteredEmailsDirectoryAndCalculateInitialWordStats(emailsDirectory st
 				emailWordFreqNormalized[linePiece] = float64(emailWordFreq[linePiece]) / float64(emailWordFreqSum)
 			}
 		}
-
-		return nil
-	})
+	}
 
 	sort.Strings(words)
 
-	return numberOfEmails, words, emailsDirectoryNumber, insideEmailWordFreqNormalized, numberOfEmailContainingWord
+	return words, insideEmailWordFreqNormalized, numberOfEmailContainingWord, nil
 }
 
 func filterStopWords(initialParsedWords []string) []string {
@@ -329,11 +659,11 @@ func filterWordsLexical(stopWordsFilteredWords []string) []string {
 	return filteredWordsList
 }
 
-func filterWordsWithLowNumberOfEmailsContainingWord(basicFilteredWords []string, numberOfEmailsContainingWord map[string]int) map[string]bool {
+func filterWordsWithLowNumberOfEmailsContainingWord(basicFilteredWords []string, numberOfEmailsContainingWord map[string]int, minimumEmailsContainingWord int) map[string]bool {
 	firstFreqFilteredWords := make(map[string]bool)
 
 	for _, word := range basicFilteredWords {
-		if numberOfEmailsContainingWord[word] >= 10 {
+		if numberOfEmailsContainingWord[word] >= minimumEmailsContainingWord {
 			firstFreqFilteredWords[word] = true
 		}
 	}
@@ -397,7 +727,7 @@ func computePerEmailSignificanceRanksForBasicFilteredWords(numberOfEmails int, p
 	return insideEmailsWordRanks
 }
 
-func filterWordsWithLowNumberOfOccurrencesInPerEmailHighRankingWords(numberOfEmails int, basicFilteredWords []string, firstFreqFilteredWords map[string]bool, perEmailSignificanceRanksForBasicFilteredWords [][]int) []string {
+func filterWordsWithLowNumberOfOccurrencesInPerEmailHighRankingWords(numberOfEmails int, basicFilteredWords []string, firstFreqFilteredWords map[string]bool, perEmailSignificanceRanksForBasicFilteredWords [][]int, thresholds Thresholds) []string {
 	wordHighRankOccurrences := make(map[string]int)
 
 	numberOfBasicFilteredWords := len(basicFilteredWords)
@@ -408,7 +738,7 @@ func filterWordsWithLowNumberOfOccurrencesInPerEmailHighRankingWords(numberOfEma
 		for i := 0; i < numberOfBasicFilteredWords; i++ {
 			word := basicFilteredWords[i]
 
-			if thisEmailSignificanceRanksForBasicFilteredWords[i] <= 100 && thisEmailSignificanceRanksForBasicFilteredWords[i] != -1 {
+			if thisEmailSignificanceRanksForBasicFilteredWords[i] <= thresholds.TopRanks && thisEmailSignificanceRanksForBasicFilteredWords[i] != -1 {
 				wordHighRankOccurrences[word]++
 			}
 		}
@@ -416,7 +746,7 @@ func filterWordsWithLowNumberOfOccurrencesInPerEmailHighRankingWords(numberOfEma
 
 	secondFreqFilteredWords := make(map[string]bool)
 	for word := range firstFreqFilteredWords {
-		if wordHighRankOccurrences[word] > 50 {
+		if wordHighRankOccurrences[word] >= thresholds.MinimumTopRankOccurrences {
 			secondFreqFilteredWords[word] = true
 		}
 	}
@@ -430,7 +760,7 @@ func filterWordsWithLowNumberOfOccurrencesInPerEmailHighRankingWords(numberOfEma
 	return secondFreqFilteredList
 }
 
-func extractPerEmailSignificanceAndSignificanceRanksForSecondFreqFilteredWords(numberOfEmails int, basicFilteredWords []string, secondFreqFilteredWords []string, perEmailSignificanceForBasicFilteredWords [][]float64, perEmailSignificanceRanksForBasicFilteredWords [][]int) ([][]float64, [][]int) {
+func extractPerEmailSignificanceAndSignificanceRanksForSecondFreqFilteredWords(numberOfEmails int, basicFilteredWords []string, secondFreqFilteredWords []string, perEmailSignificanceForBasicFilteredWords [][]float64, perEmailSignificanceRanksForBasicFilteredWords [][]int, topRanks int) ([][]float64, [][]int) {
 	wordBasicFilteredWordsIndices := make(map[string]int)
 	for wordNumber, word := range basicFilteredWords {
 		wordBasicFilteredWordsIndices[word] = wordNumber
@@ -452,7 +782,7 @@ func extractPerEmailSignificanceAndSignificanceRanksForSecondFreqFilteredWords(n
 			thisEmailWordSignificanceRankForSecondFreqFilteredWords := 1
 			rankBefore := perEmailSignificanceRanksForBasicFilteredWords[emailNumber][wordBasicFilteredWordsIndices[word]]
 
-			if rankBefore != -1 && rankBefore <= 100 {
+			if rankBefore != -1 && rankBefore <= topRanks {
 				for _, wordB := range secondFreqFilteredWords {
 					rankBeforeB := perEmailSignificanceRanksForBasicFilteredWords[emailNumber][wordBasicFilteredWordsIndices[wordB]]
 					if rankBefore > rankBeforeB && rankBeforeB != -1 {
@@ -471,7 +801,7 @@ func extractPerEmailSignificanceAndSignificanceRanksForSecondFreqFilteredWords(n
 
 }
 
-func computePerEmailCosineTailoredFeatures(numberOfEmails int, secondFilteredFreqWords []string, featuresSecondRound [][]float64, perEmailSignificanceRanksForSecondFreqFilteredWords [][]int, emailsDirectoryNumber []int) [][]uint8 {
+func computePerEmailCosineTailoredFeatures(numberOfEmails int, secondFilteredFreqWords []string, featuresSecondRound [][]float64, perEmailSignificanceRanksForSecondFreqFilteredWords [][]int, emailsDirectoryNumber []int, numberOfSecondaryFeaturesUpperBound int, statistics *helpers.Statistics, logger *helpers.Logger) ([][]uint8, error) {
 	averageSecondFreqFilteredWordsOccurrenceInPerEmailTopRankingsForBasicFilteredWords := 0
 
 	for emailNumber := 0; emailNumber < numberOfEmails; emailNumber++ {
@@ -485,11 +815,12 @@ func computePerEmailCosineTailoredFeatures(numberOfEmails int, secondFilteredFre
 
 	averageSecondFreqFilteredWordsOccurrenceInPerEmailTopRankingsForBasicFilteredWords = averageSecondFreqFilteredWordsOccurrenceInPerEmailTopRankingsForBasicFilteredWords / numberOfEmails
 
-	fmt.Println("Average second frequency filtered words occurrence in per email top rankings for basic filtered words:", averageSecondFreqFilteredWordsOccurrenceInPerEmailTopRankingsForBasicFilteredWords)
+	statistics.Add(logger, "average_second_frequency_filtered_words_occurrence_in_per_email_top_rankings_for_basic_filtered_words", averageSecondFreqFilteredWordsOccurrenceInPerEmailTopRankingsForBasicFilteredWords, nil,
+		"Average second frequency filtered words occurrence in per email top rankings for basic filtered words:", averageSecondFreqFilteredWordsOccurrenceInPerEmailTopRankingsForBasicFilteredWords)
 
 	numberOfNonZeroPrimaryFeaturesPerEmailUpperBound := averageSecondFreqFilteredWordsOccurrenceInPerEmailTopRankingsForBasicFilteredWords * 2
 
-	fmt.Println("Number of non zero primary features per email upper bound:", numberOfNonZeroPrimaryFeaturesPerEmailUpperBound)
+	statistics.Add(logger, "number_of_non_zero_primary_features_per_email_upper_bound", numberOfNonZeroPrimaryFeaturesPerEmailUpperBound, nil, "Number of non zero primary features per email upper bound:", numberOfNonZeroPrimaryFeaturesPerEmailUpperBound)
 
 	var averageNumberOfNonZeroPrimaryFeaturesPerEmail float64 = 0
 	numberOfNonZeroPrimaryFeaturesPerEmail := make([]float64, numberOfEmails)
@@ -529,26 +860,27 @@ func computePerEmailCosineTailoredFeatures(numberOfEmails int, secondFilteredFre
 
 	standardDeviationOfNumberOfNonZeroPrimaryFeaturesPerEmail /= float64(numberOfEmails)
 	standardDeviationOfNumberOfNonZeroPrimaryFeaturesPerEmail = math.Sqrt(standardDeviationOfNumberOfNonZeroPrimaryFeaturesPerEmail)
-	fmt.Println()
-	fmt.Println("Average number of non zero primary features per email:", averageNumberOfNonZeroPrimaryFeaturesPerEmail)
-	fmt.Println("Standard deviation of number of non zero primary features per email:", standardDeviationOfNumberOfNonZeroPrimaryFeaturesPerEmail)
-	fmt.Println("Per directory number:")
+	logger.EmptyLine()
+	statistics.Add(logger, "average_number_of_non_zero_primary_features_per_email", averageNumberOfNonZeroPrimaryFeaturesPerEmail, nil, "Average number of non zero primary features per email:", averageNumberOfNonZeroPrimaryFeaturesPerEmail)
+	statistics.Add(logger, "standard_deviation_of_number_of_non_zero_primary_features_per_email", standardDeviationOfNumberOfNonZeroPrimaryFeaturesPerEmail, nil, "Standard deviation of number of non zero primary features per email:", standardDeviationOfNumberOfNonZeroPrimaryFeaturesPerEmail)
+	logger.Info(helpers.EventMessage, nil, "Per directory number:")
 	for i := 0; i <= maximumDirectoryNumber; i++ {
 		averageNumberOfNonZeroPrimaryFeaturesPerEmailPerDirectoryNumber[i] /= float64(numberOfSelectedEmailPerDirectoryNumber[i])
 		standardDeviationOfNumberOfNonZeroPrimaryFeaturesPerEmailPerDirectoryNumber[i] = math.Sqrt(standardDeviationOfNumberOfNonZeroPrimaryFeaturesPerEmailPerDirectoryNumber[i] / float64(numberOfSelectedEmailPerDirectoryNumber[i]))
-		fmt.Println("\t", i, ":",
+		statistics.Add(logger, "average_number_of_non_zero_primary_features_per_email_of_directory", averageNumberOfNonZeroPrimaryFeaturesPerEmailPerDirectoryNumber[i], helpers.LogFields{"directory_number": i},
+			"\t", i, ":",
 			"(average:", strconv.FormatFloat(averageNumberOfNonZeroPrimaryFeaturesPerEmailPerDirectoryNumber[i], 'f', 3, 64),
 			") , (standard deviation:",
 			strconv.FormatFloat(standardDeviationOfNumberOfNonZeroPrimaryFeaturesPerEmailPerDirectoryNumber[i], 'f', 3, 64),
 			")")
+		statistics.Add(logger, "standard_deviation_of_number_of_non_zero_primary_features_per_email_of_directory", standardDeviationOfNumberOfNonZeroPrimaryFeaturesPerEmailPerDirectoryNumber[i], helpers.LogFields{"directory_number": i})
 	}
-	fmt.Println()
+	logger.EmptyLine()
 	/////////////////////////
 
 	toBeShuffled := make([][]uint8, numberOfEmails)
 	numberOfPrimaryFeatures := len(secondFilteredFreqWords)
 	currentNumberOfSecondaryFeatures := 0
-	numberOfSecondaryFeaturesUpperBound := 50000
 
 	for emailNumber := 0; emailNumber < numberOfEmails; emailNumber++ {
 		toBeShuffled[emailNumber] = make([]uint8, numberOfPrimaryFeatures+numberOfSecondaryFeaturesUpperBound)
@@ -571,25 +903,25 @@ func computePerEmailCosineTailoredFeatures(numberOfEmails int, secondFilteredFre
 
 		for i := numberOfTopRankingSelectedWords; i < numberOfNonZeroPrimaryFeaturesPerEmailUpperBound; i++ {
 			currentNumberOfSecondaryFeatures++
+			if currentNumberOfSecondaryFeatures > numberOfSecondaryFeaturesUpperBound {
+				return nil, &helpers.PreparationError{Stage: "computing features", Err: fmt.Errorf("number of secondary features exceeds the upper bound %d", numberOfSecondaryFeaturesUpperBound)}
+			}
 			toBeShuffled[emailNumber][numberOfPrimaryFeatures-1+currentNumberOfSecondaryFeatures] = 1
 		}
 	}
 
-	if currentNumberOfSecondaryFeatures > numberOfSecondaryFeaturesUpperBound {
-		panic("Not finished successfully")
-	} else {
-		finalNumberOfFeatures := numberOfPrimaryFeatures + currentNumberOfSecondaryFeatures
-		fmt.Println("Number of features per email:", finalNumberOfFeatures)
-		fmt.Println("Number of primary features per email:", numberOfPrimaryFeatures)
-		fmt.Println("Number of secondary features per email:", currentNumberOfSecondaryFeatures)
-		fmt.Println("Average number of secondary non zero features per email:", float64(currentNumberOfSecondaryFeatures)/float64(numberOfEmails))
+	finalNumberOfFeatures := numberOfPrimaryFeatures + currentNumberOfSecondaryFeatures
+	statistics.Add(logger, "number_of_features_per_email", finalNumberOfFeatures, nil, "Number of features per email:", finalNumberOfFeatures)
+	statistics.Add(logger, "number_of_primary_features_per_email", numberOfPrimaryFeatures, nil, "Number of primary features per email:", numberOfPrimaryFeatures)
+	statistics.Add(logger, "number_of_secondary_features_per_email", currentNumberOfSecondaryFeatures, nil, "Number of secondary features per email:", currentNumberOfSecondaryFeatures)
+	averageNumberOfSecondaryNonZeroFeaturesPerEmail := float64(currentNumberOfSecondaryFeatures) / float64(numberOfEmails)
+	statistics.Add(logger, "average_number_of_secondary_non_zero_features_per_email", averageNumberOfSecondaryNonZeroFeaturesPerEmail, nil, "Average number of secondary non zero features per email:", averageNumberOfSecondaryNonZeroFeaturesPerEmail)
 
-		for emailNumber := 0; emailNumber < numberOfEmails; emailNumber++ {
-			toBeShuffled[emailNumber] = toBeShuffled[emailNumber][:finalNumberOfFeatures]
-		}
+	for emailNumber := 0; emailNumber < numberOfEmails; emailNumber++ {
+		toBeShuffled[emailNumber] = toBeShuffled[emailNumber][:finalNumberOfFeatures]
 	}
 
-	return toBeShuffled
+	return toBeShuffled, nil
 }
 
 func combineFeaturesWithEmailDirectoryNumber(features [][]uint8, emailDirectoryNumbers []int) [][]uint8 {
@@ -610,35 +942,19 @@ func combineFeaturesWithEmailDirectoryNumber(features [][]uint8, emailDirectoryN
 	return combined
 }
 
-func scrambleTheSortingOfEmailsAndWriteToFile(numberOfEmails int, toBeShuffled [][]uint8, outputFilePath string) {
+// The email paths are shuffled along with the rows.
+func scrambleTheSortingOfEmails(toBeShuffled [][]uint8, emailPaths []string) {
 	randomGenerator := rand.New(rand.NewSource(5665343934110297328))
 	randomGenerator.Shuffle(len(toBeShuffled), func(i, j int) {
 		toBeShuffled[i], toBeShuffled[j] = toBeShuffled[j], toBeShuffled[i]
+		emailPaths[i], emailPaths[j] = emailPaths[j], emailPaths[i]
 	})
-
-	shuffled := toBeShuffled
-
-	csv := strings.Builder{}
-
-	for i := 0; i < numberOfEmails; i++ {
-		csv.WriteString(strconv.Itoa(int(shuffled[i][0])))
-		for j := 1; j < len(shuffled[i]); j++ {
-			csv.WriteString(",")
-			csv.WriteString(strconv.Itoa(int(shuffled[i][j])))
-		}
-		csv.WriteString("\r\n")
-	}
-
-	bytesCsv, err := ioutil.ReadAll(strings.NewReader(csv.String()))
-	if err != nil {
-		panic("Not finished successfully.")
-	}
-	ioutil.WriteFile(outputFilePath, bytesCsv, 600)
 }
 
-func computeKnnClassificationAccuracy(shuffled [][]uint8, k int) {
-	fmt.Println("Computing KNN majority voting classification accuracy")
+func computeKnnClassificationAccuracy(ctx context.Context, shuffled [][]uint8, k int, logger *helpers.Logger) (*KnnEvaluation, error) {
+	logger.Info(helpers.EventMessage, helpers.LogFields{"k": k}, "Computing KNN majority voting classification accuracy")
 
+	evaluation := &KnnEvaluation{K: k}
 	numberOfEmails := len(shuffled)
 	numberOfFeatures := len(shuffled[0]) - 1
 	numberOfCorrects := 0
@@ -658,8 +974,11 @@ func computeKnnClassificationAccuracy(shuffled [][]uint8, k int) {
 	}
 
 	for emailNumber := 0; emailNumber < numberOfEmails; emailNumber++ {
+		if ctx.Err() != nil {
+			return nil, &helpers.PreparationError{Stage: "computing KNN accuracy", Err: ctx.Err()}
+		}
 		if emailNumber%100 == 0 {
-			fmt.Println("Please wait...", emailNumber, "/", numberOfEmails)
+			logger.Info("knn_progress", helpers.LogFields{"email_number": emailNumber, "number_of_emails": numberOfEmails}, "Please wait...", emailNumber, "/", numberOfEmails)
 		}
 		emailFeatures := shuffled[emailNumber][1:]
 		if shuffled[emailNumber][0]+1 > numberOfDirectories {
@@ -702,7 +1021,10 @@ func computeKnnClassificationAccuracy(shuffled [][]uint8, k int) {
 		neighboursDirectoryNumberOccurrences := make(map[uint8]int)
 
 		for i := 0; i < k; i++ {
-			directoryNumberAndFeatures, _ := priorityQueue.Dequeue()
+			directoryNumberAndFeatures, ok := priorityQueue.Dequeue()
+			if !ok {
+				break
+			}
 			directoryNumber := directoryNumberAndFeatures.([]uint8)[0]
 			neighboursDirectoryNumberOccurrences[directoryNumber]++
 		}
@@ -719,7 +1041,7 @@ func computeKnnClassificationAccuracy(shuffled [][]uint8, k int) {
 		}
 
 		if maxOccurrences == -1 {
-			panic("Not finished successfully.")
+			return nil, &helpers.PreparationError{Stage: "computing KNN accuracy", Err: fmt.Errorf("no neighbour found for email %d", emailNumber)}
 		}
 
 		confusionMatrix[shuffled[emailNumber][0]][maxOccurrenceDirectoryNumber]++
@@ -731,24 +1053,102 @@ func computeKnnClassificationAccuracy(shuffled [][]uint8, k int) {
 	}
 
 	var accuracy float64 = 100.0 * float64(numberOfCorrects) / float64(numberOfEmails)
-	fmt.Println("Accuracy:", accuracy, "%")
-	fmt.Println("Confusion matrix:")
+	evaluation.AccuracyPercent = accuracy
+	evaluation.Statistics.Add(logger, "knn_accuracy_percent", accuracy, helpers.LogFields{"k": k}, "Accuracy:", accuracy, "%")
+	logger.Info(helpers.EventMessage, nil, "Confusion matrix:")
+	evaluation.ConfusionMatrixPercent = make([][]float64, numberOfDirectories)
 	for directoryNumber1 = 0; directoryNumber1 < numberOfDirectories; directoryNumber1++ {
+		evaluation.ConfusionMatrixPercent[directoryNumber1] = make([]float64, numberOfDirectories)
+		confusionMatrixRow := ""
 		for directoryNumber2 = 0; directoryNumber2 < numberOfDirectories; directoryNumber2++ {
 			var confusion float64 = float64(confusionMatrix[directoryNumber1][directoryNumber2]) / float64(numberOfEmails)
-			fmt.Print(strconv.FormatFloat(confusion*100, 'f', 2, 64), "% , ")
+			evaluation.ConfusionMatrixPercent[directoryNumber1][directoryNumber2] = confusion * 100
+			confusionMatrixRow += strconv.FormatFloat(confusion*100, 'f', 2, 64) + "% , "
+			evaluation.Statistics.Add(logger, "knn_confusion_percent", confusion*100, helpers.LogFields{"k": k, "actual_directory_number": directoryNumber1, "predicted_directory_number": directoryNumber2})
 		}
-		fmt.Println()
+		logger.Info(helpers.EventMessage, nil, confusionMatrixRow)
 	}
+
+	return evaluation, nil
 }
 
-func Run(outputDirectory string, prefixOfInputDownloadURL string, inputDownloadUrls string, parameters []string) {
+func init() {
+	helpers.RegisterPreparation(helpers.RegisteredPreparation{
+		Name:                      "emails_features_1",
+		Description:               "prepare per-email word features of labelled emails (e.g. the Enron corpus) and compute KNN accuracy",
+		NumberOfInputDownloadURLs: 1,
+		Parameters: []helpers.PreparationParameter{
+			{Name: "labels", Description: "comma separated class labels, at least two classes: mailbox names (e.g. sent,inbox), globs of mailbox paths (e.g. */discussion_threads) or re:<regular expression>, each optionally as <class>=<pattern> to merge several into a class (e.g. sent=sent,sent=sent_items,inbox)", Type: helpers.ParameterTypeList, Required: true},
+			{Name: "source", Description: "where the emails of the labels are: enron (directories of one email per file), maildir (cur and new of maildir folders), mbox (mbox files) or eml (directories of .eml files)", Type: helpers.ParameterTypeString, Default: SourceEnron},
+			{Name: "parser", Description: "how emails are parsed: enron (the subject line and the lines after X-FileName as is, as the earlier versions did, falling back to mime for emails without X-FileName) or mime (headers, multipart bodies, transfer encodings and charsets)", Type: helpers.ParameterTypeString, Default: ParserEnron},
+			{Name: "minimum_emails_per_label", Description: "number of emails a mailbox needs to be used for a label", Type: helpers.ParameterTypeInt, Default: strconv.Itoa(DefaultThresholds.MinimumEmailsPerLabel)},
+			{Name: "maximum_emails_per_label", Description: "number of emails selected from the mailbox of each label", Type: helpers.ParameterTypeInt, Default: strconv.Itoa(DefaultThresholds.MaximumEmailsPerLabel)},
+			{Name: "minimum_emails_containing_word", Description: "number of emails a word has to be in to be a feature", Type: helpers.ParameterTypeInt, Default: strconv.Itoa(DefaultThresholds.MinimumEmailsContainingWord)},
+			{Name: "top_ranks", Description: "significance rank up to which a word is in the top rankings of an email", Type: helpers.ParameterTypeInt, Default: strconv.Itoa(DefaultThresholds.TopRanks)},
+			{Name: "minimum_top_rank_occurrences", Description: "number of emails a word has to be in the top rankings of to be a feature", Type: helpers.ParameterTypeInt, Default: strconv.Itoa(DefaultThresholds.MinimumTopRankOccurrences)},
+			{Name: "maximum_secondary_features", Description: "upper bound of the number of secondary features", Type: helpers.ParameterTypeInt, Default: strconv.Itoa(DefaultThresholds.MaximumSecondaryFeatures)},
+			{Name: "sampling", Description: "how the emails of each mailbox are selected: first (the first emails, as the earlier versions did) or random (drawn with -sampling-seed)", Type: helpers.ParameterTypeString, Default: SamplingFirst},
+			{Name: "sampling_seed", Description: "seed of the random sampling", Type: helpers.ParameterTypeInt, Default: "0"},
+			{Name: "mailbox_allocation", Description: "how the emails of a class are shared out to its mailboxes: even or proportional (to their numbers of emails)", Type: helpers.ParameterTypeString, Default: AllocationEven},
+		},
+		Prepare: func(ctx context.Context, request *helpers.PreparationRequest) error {
+			labels := request.Parameters.Strings("labels")
+			if _, err := parseLabels(labels); err != nil {
+				return &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("emails_features_1: %w", errors.Unwrap(err))}
+			}
+			parser := request.Parameters.String("parser")
+			if parser != ParserEnron && parser != ParserMime {
+				return &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("emails_features_1: unknown email parser %q (expected %s or %s)", parser, ParserEnron, ParserMime)}
+			}
+			source, err := LookupEmailSource(request.Parameters.String("source"))
+			if err != nil {
+				return &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("emails_features_1: %w", err)}
+			}
+			thresholds := Thresholds{
+				MinimumEmailsPerLabel:       request.Parameters.Int("minimum_emails_per_label"),
+				MaximumEmailsPerLabel:       request.Parameters.Int("maximum_emails_per_label"),
+				MinimumEmailsContainingWord: request.Parameters.Int("minimum_emails_containing_word"),
+				TopRanks:                    request.Parameters.Int("top_ranks"),
+				MinimumTopRankOccurrences:   request.Parameters.Int("minimum_top_rank_occurrences"),
+				MaximumSecondaryFeatures:    request.Parameters.Int("maximum_secondary_features"),
+			}
+			if _, err := thresholds.WithDefaults(); err != nil {
+				return &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("emails_features_1: %w", errors.Unwrap(err))}
+			}
+			sampling := Sampling{Method: request.Parameters.String("sampling"), Seed: int64(request.Parameters.Int("sampling_seed")), Allocation: request.Parameters.String("mailbox_allocation")}
+			if _, err := sampling.WithDefaults(); err != nil {
+				return &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("emails_features_1: %w", errors.Unwrap(err))}
+			}
+			return Run(ctx, request.OutputDirectory, request.PrefixOfInputDownloadURLs, request.InputDownloadURLs[0], Options{Labels: labels, Source: source, Parser: parser, Thresholds: thresholds, Sampling: sampling}, request.Options)
+		},
+	})
+}
+
+func Run(ctx context.Context, outputDirectory string, prefixOfInputDownloadURL string, inputDownloadUrls string, featuresOptions Options, options helpers.PreparationOptions) error {
+	thresholds, err := featuresOptions.Thresholds.WithDefaults()
+	if err != nil {
+		return err
+	}
+	featuresOptions.Thresholds = thresholds
+
+	sampling, err := featuresOptions.Sampling.WithDefaults()
+	if err != nil {
+		return err
+	}
+	featuresOptions.Sampling = sampling
+
 	dataSetsPreparationInformation := new(helpers.DataSetPreparationInformation)
+	dataSetsPreparationInformation.Name = "emails_features_1"
 
 	dataSetsPreparationInformation.PrefixOfInputDownloadURLs = prefixOfInputDownloadURL
 	dataSetsPreparationInformation.InputDownloadURLs = []string{inputDownloadUrls}
-	dataSetsPreparationInformation.Parameters = parameters
-	dataSetsPreparationInformation.Preparation = EmailFeaturesPreparation{}
+	dataSetsPreparationInformation.Parameters = featuresOptions.Labels
+	dataSetsPreparationInformation.Settings = thresholds.settings()
+	for name, value := range sampling.settings() {
+		dataSetsPreparationInformation.Settings[name] = value
+	}
+	dataSetsPreparationInformation.Preparation = EmailFeaturesPreparation{Options: featuresOptions}
+	dataSetsPreparationInformation.Options = options
 
-	dataSetsPreparationInformation.Prepare(outputDirectory)
+	return dataSetsPreparationInformation.Prepare(ctx, outputDirectory)
 }
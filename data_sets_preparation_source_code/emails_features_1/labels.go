@@ -0,0 +1,125 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package emails_features_1
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+import helpers "github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+
+const regexLabelPrefix = "re:"
+
+type labelClass struct {
+	name     string
+	patterns []labelPattern
+}
+
+type labelPattern struct {
+	text  string
+	name  string
+	glob  string
+	regex *regexp.Regexp
+}
+
+// Labels are [<class>=]<pattern>, the class being the pattern if not given. Labels of the same class are merged, and classes are numbered in order of appearance.
+//
+// A pattern is a mailbox name (the first such mailbox with MinimumEmailsPerLabel emails), a glob of the end of mailbox paths (of names if it has no /, of whole paths if it starts with /), or re:<regular expression> of paths.
+//
+// Patterns cannot have commas, which separate labels on the command line.
+func parseLabels(labels []string) ([]labelClass, error) {
+	classes := []labelClass{}
+	classNumbers := make(map[string]int)
+	for _, label := range labels {
+		className, patternText := label, label
+		if equalsIndex := strings.Index(label, "="); equalsIndex != -1 && !strings.HasPrefix(label, regexLabelPrefix) {
+			className, patternText = label[:equalsIndex], label[equalsIndex+1:]
+		}
+		if className == "" || patternText == "" {
+			return nil, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("label %q has an empty class or pattern", label)}
+		}
+
+		pattern, err := parseLabelPattern(patternText)
+		if err != nil {
+			return nil, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("label %q: %w", label, err)}
+		}
+
+		classNumber, isKnown := classNumbers[className]
+		if !isKnown {
+			classNumber = len(classes)
+			classNumbers[className] = classNumber
+			classes = append(classes, labelClass{name: className})
+		}
+		classes[classNumber].patterns = append(classes[classNumber].patterns, pattern)
+	}
+
+	if len(classes) < 2 {
+		return nil, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("at least two label classes are needed, got %d", len(classes))}
+	}
+	return classes, nil
+}
+
+func parseLabelPattern(text string) (labelPattern, error) {
+	if strings.HasPrefix(text, regexLabelPrefix) {
+		regex, err := regexp.Compile(strings.TrimPrefix(text, regexLabelPrefix))
+		if err != nil {
+			return labelPattern{}, err
+		}
+		return labelPattern{text: text, regex: regex}, nil
+	}
+
+	if !strings.ContainsAny(text, "*?[/\\") {
+		return labelPattern{text: text, name: text}, nil
+	}
+	if _, err := path.Match(text, ""); err != nil {
+		return labelPattern{}, fmt.Errorf("invalid glob %q: %w", text, err)
+	}
+	return labelPattern{text: text, glob: text}, nil
+}
+
+func (pattern labelPattern) isMailboxName() bool {
+	return pattern.name != ""
+}
+
+func (pattern labelPattern) matches(mailbox Mailbox) bool {
+	switch {
+	case pattern.regex != nil:
+		return pattern.regex.MatchString(mailbox.Path())
+	case pattern.glob != "":
+		if !strings.Contains(pattern.glob, "/") {
+			isMatch, _ := path.Match(pattern.glob, mailbox.Name())
+			return isMatch
+		}
+		return matchPathEnd(pattern.glob, mailbox.Path())
+	default:
+		return pattern.name == mailbox.Name()
+	}
+}
+
+// Matches the glob against the last elements of the path, or against the whole path if the glob starts with /.
+func matchPathEnd(glob string, mailboxPath string) bool {
+	isAnchored := strings.HasPrefix(glob, "/")
+	globElements := strings.Split(strings.Trim(glob, "/"), "/")
+	pathElements := strings.Split(mailboxPath, "/")
+	if len(globElements) > len(pathElements) || isAnchored && len(globElements) != len(pathElements) {
+		return false
+	}
+
+	pathElements = pathElements[len(pathElements)-len(globElements):]
+	for i, globElement := range globElements {
+		isMatch, _ := path.Match(globElement, pathElements[i])
+		if !isMatch {
+			return false
+		}
+	}
+	return true
+}
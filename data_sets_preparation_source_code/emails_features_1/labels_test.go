@@ -0,0 +1,210 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package emails_features_1
+
+import (
+	"context"
+	"errors"
+	"path"
+	"reflect"
+	"testing"
+)
+
+import helpers "github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+
+// A mailbox with a path and a number of emails, for matching labels.
+type countOnlyMailbox struct {
+	path           string
+	numberOfEmails int
+}
+
+func (mailbox countOnlyMailbox) Name() string {
+	return path.Base(mailbox.path)
+}
+
+func (mailbox countOnlyMailbox) Path() string {
+	return mailbox.path
+}
+
+func (mailbox countOnlyMailbox) NumberOfEmails() (int, error) {
+	return mailbox.numberOfEmails, nil
+}
+
+func (mailbox countOnlyMailbox) ReadEmails(ctx context.Context, isSelected func(emailNumber int) bool, yield func(email Email) error) error {
+	return nil
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name            string
+		labels          []string
+		expectedClasses map[string][]string
+		expectedOrder   []string
+		expectError     bool
+	}{
+		{name: "one class per label", labels: []string{"sent", "inbox"}, expectedOrder: []string{"sent", "inbox"}, expectedClasses: map[string][]string{"sent": {"sent"}, "inbox": {"inbox"}}},
+		{
+			name:            "labels of the same class are merged",
+			labels:          []string{"mine=sent", "other=inbox", "mine=*/sent_items", "other=re:/inbox[0-9]$"},
+			expectedOrder:   []string{"mine", "other"},
+			expectedClasses: map[string][]string{"mine": {"sent", "*/sent_items"}, "other": {"inbox", "re:/inbox[0-9]$"}},
+		},
+		{name: "an = inside a regular expression is not a class", labels: []string{"re:a=b", "inbox"}, expectedOrder: []string{"re:a=b", "inbox"}, expectedClasses: map[string][]string{"re:a=b": {"re:a=b"}, "inbox": {"inbox"}}},
+		{name: "a single class", labels: []string{"a=sent", "a=inbox"}, expectError: true},
+		{name: "an empty class", labels: []string{"=sent", "inbox"}, expectError: true},
+		{name: "an empty pattern", labels: []string{"a=", "inbox"}, expectError: true},
+		{name: "an invalid regular expression", labels: []string{"re:(", "inbox"}, expectError: true},
+		{name: "an invalid glob", labels: []string{"[/x", "inbox"}, expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			classes, err := parseLabels(test.labels)
+			if test.expectError {
+				var preparationError *helpers.PreparationError
+				if !errors.As(err, &preparationError) || preparationError.Stage != helpers.StageArguments {
+					t.Fatalf("expected an arguments error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			order := []string{}
+			patterns := map[string][]string{}
+			for _, class := range classes {
+				order = append(order, class.name)
+				for _, pattern := range class.patterns {
+					patterns[class.name] = append(patterns[class.name], pattern.text)
+				}
+			}
+			if !reflect.DeepEqual(order, test.expectedOrder) || !reflect.DeepEqual(patterns, test.expectedClasses) {
+				t.Errorf("classes %v %v, expected %v %v", order, patterns, test.expectedOrder, test.expectedClasses)
+			}
+		})
+	}
+}
+
+func TestLabelPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		mailboxPath string
+		expected    bool
+	}{
+		{"sent", "maildir/allen-p/sent", true},
+		{"sent", "maildir/allen-p/sent_items", false},
+		{"sent*", "maildir/allen-p/sent_items", true},
+		{"*/sent", "maildir/allen-p/sent", true},
+		{"allen-p/*", "maildir/allen-p/inbox", true},
+		{"allen-p/*", "maildir/allen-p/inbox/archive", false},
+		{"maildir/*", "maildir/allen-p/inbox", false},
+		{"/maildir/*/inbox", "maildir/allen-p/inbox", true},
+		{"/*/inbox", "maildir/allen-p/inbox", false},
+		{"/allen-p/inbox", "maildir/allen-p/inbox", false},
+		{"re:allen-.*/inbox$", "maildir/allen-p/inbox", true},
+		{"re:^allen", "maildir/allen-p/inbox", false},
+		{"re:inbox", "maildir/allen-p/inbox_old", true},
+	}
+
+	for _, test := range tests {
+		pattern, err := parseLabelPattern(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isMatch := pattern.matches(countOnlyMailbox{path: test.mailboxPath}); isMatch != test.expected {
+			t.Errorf("%q matching %q is %v, expected %v", test.pattern, test.mailboxPath, isMatch, test.expected)
+		}
+	}
+}
+
+func TestMatchClassMailboxes(t *testing.T) {
+	mailboxes := []Mailbox{
+		countOnlyMailbox{path: "maildir/a/sent", numberOfEmails: 5},
+		countOnlyMailbox{path: "maildir/b/sent", numberOfEmails: 500},
+		countOnlyMailbox{path: "maildir/a/inbox", numberOfEmails: 400},
+		countOnlyMailbox{path: "maildir/b/inbox", numberOfEmails: 0},
+		countOnlyMailbox{path: "maildir/b/discussion_threads", numberOfEmails: 10},
+	}
+
+	tests := []struct {
+		name              string
+		labels            []string
+		expectedMailboxes [][]string
+		expectError       bool
+	}{
+		{
+			name:              "a mailbox name takes the first mailbox with enough emails",
+			labels:            []string{"sent", "inbox"},
+			expectedMailboxes: [][]string{{"maildir/b/sent"}, {"maildir/a/inbox"}},
+		},
+		{
+			name:              "globs take every matching mailbox with emails, in the order of the source",
+			labels:            []string{"sent=*/sent", "other=b/discussion_*"},
+			expectedMailboxes: [][]string{{"maildir/a/sent", "maildir/b/sent"}, {"maildir/b/discussion_threads"}},
+		},
+		{
+			name:              "a mailbox name below the minimum number of emails is not taken",
+			labels:            []string{"discussion_threads", "inbox"},
+			expectedMailboxes: [][]string{{}, {"maildir/a/inbox"}},
+		},
+		{
+			name:              "merged patterns",
+			labels:            []string{"mine=re:^maildir/a/", "theirs=*/discussion_threads", "theirs=/maildir/b/inbox"},
+			expectedMailboxes: [][]string{{"maildir/a/sent", "maildir/a/inbox"}, {"maildir/b/discussion_threads"}},
+		},
+		{
+			name:              "a class without mailboxes",
+			labels:            []string{"sent", "drafts"},
+			expectedMailboxes: [][]string{{"maildir/b/sent"}, {}},
+		},
+		{
+			name:        "a mailbox matched by two classes",
+			labels:      []string{"all=re:maildir", "sent"},
+			expectError: true,
+		},
+		{
+			name:        "a mailbox matched by a glob of another class",
+			labels:      []string{"sent=*/sent", "other=maildir/b/*"},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			classes, err := parseLabels(test.labels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			classMailboxes, err := matchClassMailboxes(classes, mailboxes, 300)
+			if test.expectError {
+				var preparationError *helpers.PreparationError
+				if !errors.As(err, &preparationError) {
+					t.Fatalf("expected a PreparationError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			paths := [][]string{}
+			for _, mailboxes := range classMailboxes {
+				classPaths := []string{}
+				for _, mailbox := range mailboxes {
+					classPaths = append(classPaths, mailbox.Path())
+				}
+				paths = append(paths, classPaths)
+			}
+			if !reflect.DeepEqual(paths, test.expectedMailboxes) {
+				t.Errorf("mailboxes %v, expected %v", paths, test.expectedMailboxes)
+			}
+		})
+	}
+}
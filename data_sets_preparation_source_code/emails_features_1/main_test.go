@@ -0,0 +1,22 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package emails_features_1
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+import helpers "github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+
+func TestMain(m *testing.M) {
+	helpers.Log.SetConsole(ioutil.Discard)
+	os.Exit(m.Run())
+}
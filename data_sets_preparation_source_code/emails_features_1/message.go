@@ -0,0 +1,263 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package emails_features_1
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/text/encoding/htmlindex"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+import helpers "github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+
+const (
+	// The tokenizer of the published data sets: the subject line and the lines after the X-FileName header, as is.
+	// Emails without X-FileName are parsed as MIME messages.
+	ParserEnron = "enron"
+
+	// RFC 5322 messages: the subject and the decoded text/plain (or else text/html) parts.
+	ParserMime = "mime"
+)
+
+// Nested multiparts deeper than this are skipped.
+const maximumMultipartDepth = 10
+
+// The lowercase lines of an email to be tokenized: its subject line first and then its body.
+func emailLines(email Email, parser string, logger *helpers.Logger) ([]string, error) {
+	switch parser {
+	case "", ParserEnron:
+		lines, hasXFileName := enronEmailLines(email.Content)
+		if !hasXFileName {
+			logger.Debug("email_without_x_filename", helpers.LogFields{"email": email.Path})
+			return mimeEmailLines(email.Content), nil
+		}
+		return lines, nil
+	case ParserMime:
+		return mimeEmailLines(email.Content), nil
+	default:
+		return nil, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("unknown email parser %q (expected %s or %s)", parser, ParserEnron, ParserMime)}
+	}
+}
+
+func enronEmailLines(content []byte) (lines []string, hasXFileName bool) {
+	lines = strings.Split(strings.ToLower(string(content)), "\n")
+
+	trimLineNumber := -1
+	subjectLine := []string{""}
+	for lineNumber, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "x-filename:") {
+			trimLineNumber = lineNumber
+		}
+
+		if strings.HasPrefix(line, "subject:") && trimLineNumber == -1 {
+			subjectLine[0] = line
+		}
+	}
+
+	if trimLineNumber == -1 {
+		return nil, false
+	}
+
+	lines = lines[trimLineNumber+1:]
+	return append(subjectLine, lines...), true
+}
+
+// The subject line keeps its header name, as the earlier versions tokenized it (subject is a stop word).
+func mimeEmailLines(content []byte) []string {
+	message, err := mail.ReadMessage(bytes.NewReader(content))
+	if err != nil {
+		return strings.Split(strings.ToLower(string(content)), "\n")
+	}
+
+	subjectLine := ""
+	if _, hasSubject := message.Header["Subject"]; hasSubject {
+		subject, err := headerDecoder.DecodeHeader(message.Header.Get("Subject"))
+		if err != nil {
+			subject = message.Header.Get("Subject")
+		}
+		subjectLine = strings.TrimSpace("subject: " + subject)
+	}
+
+	plainParts, htmlParts := bodyTextParts(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body, 0)
+	body := strings.Join(plainParts, "\n")
+	if len(plainParts) == 0 {
+		body = strings.Join(htmlParts, "\n")
+	}
+
+	return append([]string{strings.ToLower(subjectLine)}, strings.Split(strings.ToLower(body), "\n")...)
+}
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	content, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	text, err := decodeCharset(charset, content)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(text), nil
+}
+
+// ASCII and UTF-8 are kept as is, as the earlier versions did.
+func decodeCharset(charset string, content []byte) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "us-ascii", "ascii", "ansi_x3.4-1968", "iso646-us", "utf-8", "utf8":
+		return string(content), nil
+	}
+
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return "", err
+	}
+	decoded, err := encoding.NewDecoder().Bytes(content)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// The decoded text/plain and text/html parts of a body.
+func bodyTextParts(contentType string, transferEncoding string, body io.Reader, depth int) (plainParts []string, htmlParts []string) {
+	mediaType, parameters, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+		parameters = map[string]string{}
+	}
+
+	rawBody, _ := ioutil.ReadAll(body)
+	decodedBody, err := ioutil.ReadAll(transferDecoder(transferEncoding, bytes.NewReader(rawBody)))
+	if err != nil {
+		// Broken quoted-printable or base64 is read without decoding it.
+		decodedBody = rawBody
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maximumMultipartDepth {
+		partsPlain, partsHTML, err := multipartTextParts(decodedBody, parameters["boundary"], depth)
+		if err == nil {
+			return partsPlain, partsHTML
+		}
+		mediaType = "text/plain"
+	}
+
+	switch mediaType {
+	case "text/plain", "text/html":
+		text, err := decodeCharset(parameters["charset"], decodedBody)
+		if err != nil {
+			text = string(decodedBody)
+		}
+		if mediaType == "text/html" {
+			return nil, []string{stripHTML(text)}
+		}
+		return []string{text}, nil
+	case "message/rfc822":
+		if depth < maximumMultipartDepth {
+			message, err := mail.ReadMessage(bytes.NewReader(decodedBody))
+			if err == nil {
+				return bodyTextParts(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body, depth+1)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func multipartTextParts(body []byte, boundary string, depth int) (plainParts []string, htmlParts []string, err error) {
+	if boundary == "" {
+		return nil, nil, errors.New("multipart body without a boundary")
+	}
+
+	multipartReader := multipart.NewReader(bytes.NewReader(body), boundary)
+	numberOfParts := 0
+	for {
+		part, err := multipartReader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if numberOfParts == 0 {
+				return nil, nil, err
+			}
+			break
+		}
+		numberOfParts++
+
+		disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if disposition == "attachment" {
+			continue
+		}
+
+		partPlain, partHTML := bodyTextParts(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, depth+1)
+		plainParts = append(plainParts, partPlain...)
+		htmlParts = append(htmlParts, partHTML...)
+	}
+
+	return plainParts, htmlParts, nil
+}
+
+func transferDecoder(transferEncoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{reader: body})
+	default:
+		return body
+	}
+}
+
+// Drops the characters that are not part of base64, such as trailing spaces.
+type base64Cleaner struct {
+	reader io.Reader
+}
+
+func (cleaner *base64Cleaner) Read(buffer []byte) (int, error) {
+	for {
+		n, err := cleaner.reader.Read(buffer)
+		kept := 0
+		for _, character := range buffer[:n] {
+			if character >= 'A' && character <= 'Z' || character >= 'a' && character <= 'z' || character >= '0' && character <= '9' || character == '+' || character == '/' || character == '=' {
+				buffer[kept] = character
+				kept++
+			}
+		}
+		if kept != 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+var (
+	htmlScriptsAndStyles = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)\s*>`)
+	htmlComments         = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlLineBreakTags    = regexp.MustCompile(`(?i)<(br|p|div|tr|li|h[1-6])\b`)
+	htmlTags             = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+func stripHTML(text string) string {
+	text = htmlScriptsAndStyles.ReplaceAllString(text, " ")
+	text = htmlComments.ReplaceAllString(text, " ")
+	text = htmlLineBreakTags.ReplaceAllString(text, "\n$0")
+	text = htmlTags.ReplaceAllString(text, " ")
+	return html.UnescapeString(text)
+}
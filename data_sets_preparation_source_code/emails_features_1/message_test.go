@@ -0,0 +1,128 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package emails_features_1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The lines with their spaces trimmed and the empty ones dropped, as the tokenizer ignores both.
+func nonEmptyLines(lines []string) []string {
+	result := []string{}
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+func TestEmailLines(t *testing.T) {
+	tests := []struct {
+		name          string
+		parser        string
+		content       string
+		expectedLines []string
+	}{
+		{
+			name:   "enron keeps the lines after X-FileName as is",
+			parser: ParserEnron,
+			content: "Subject: Meeting\r\nX-FileName: allen.nsf\r\n\r\n" +
+				"Content-Transfer-Encoding would not matter =3D here\r\n",
+			expectedLines: []string{"subject: meeting", "content-transfer-encoding would not matter =3d here"},
+		},
+		{
+			name:          "enron falls back to MIME without X-FileName",
+			parser:        ParserEnron,
+			content:       "Subject: Fallback\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nsoft=\r\nbreak and =3D sign\r\n",
+			expectedLines: []string{"subject: fallback", "softbreak and = sign"},
+		},
+		{
+			name:          "quoted-printable",
+			parser:        ParserMime,
+			content:       "Subject: QP\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nCaf=C3=A9 au=\r\n lait\r\n",
+			expectedLines: []string{"subject: qp", "café au lait"},
+		},
+		{
+			name:          "base64 with whitespace",
+			parser:        ParserMime,
+			content:       "Subject: B64\r\nContent-Transfer-Encoding: base64\r\n\r\nSGVsbG8g \r\n\tV29y bGQh\r\n",
+			expectedLines: []string{"subject: b64", "hello world!"},
+		},
+		{
+			name:          "ISO-8859-1 body and RFC 2047 subject",
+			parser:        ParserMime,
+			content:       "Subject: =?ISO-8859-1?Q?R=E9sum=E9?= and =?UTF-8?B?w7xiZXI=?=\r\nContent-Type: text/plain; charset=ISO-8859-1\r\n\r\nna\xefve fa\xe7ade\r\n",
+			expectedLines: []string{"subject: résumé and über", "naïve façade"},
+		},
+		{
+			name:   "multipart/alternative prefers text/plain",
+			parser: ParserMime,
+			content: "Subject: Alternative\r\nMIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=b1\r\n\r\n" +
+				"--b1\r\nContent-Type: text/html\r\n\r\n<p>html version</p>\r\n" +
+				"--b1\r\nContent-Type: text/plain\r\n\r\nplain version\r\n" +
+				"--b1--\r\n",
+			expectedLines: []string{"subject: alternative", "plain version"},
+		},
+		{
+			name:   "HTML only body",
+			parser: ParserMime,
+			content: "Subject: Html\r\nContent-Type: text/html; charset=utf-8\r\n\r\n" +
+				"<html><head><style>p { color: red }</style><script>var x = 1;</script></head>" +
+				"<body><!-- hidden --><p>First &amp; foremost</p><div>second<br>third</div></body></html>\r\n",
+			expectedLines: []string{"subject: html", "first & foremost", "second", "third"},
+		},
+		{
+			name:   "attachments are skipped",
+			parser: ParserMime,
+			content: "Subject: Attached\r\nContent-Type: multipart/mixed; boundary=\"outer\"\r\n\r\n" +
+				"--outer\r\nContent-Type: text/plain\r\n\r\nsee attached\r\n" +
+				"--outer\r\nContent-Type: text/plain; name=notes.txt\r\nContent-Disposition: attachment; filename=notes.txt\r\n\r\nattached notes\r\n" +
+				"--outer\r\nContent-Type: application/pdf\r\nContent-Transfer-Encoding: base64\r\n\r\nJVBERi0xLjQK\r\n" +
+				"--outer--\r\n",
+			expectedLines: []string{"subject: attached", "see attached"},
+		},
+		{
+			name:   "forwarded message",
+			parser: ParserMime,
+			content: "Subject: Fwd\r\nContent-Type: multipart/mixed; boundary=m\r\n\r\n" +
+				"--m\r\nContent-Type: message/rfc822\r\n\r\nSubject: Inner\r\nContent-Type: text/plain\r\n\r\ninner body\r\n" +
+				"--m--\r\n",
+			expectedLines: []string{"subject: fwd", "inner body"},
+		},
+		{
+			name:          "content that is not a message is all body",
+			parser:        ParserMime,
+			content:       "just some text\nwithout headers",
+			expectedLines: []string{"just some text", "without headers"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines, err := emailLines(Email{Path: "test", Content: []byte(test.content)}, test.parser, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(nonEmptyLines(lines), test.expectedLines) {
+				t.Errorf("lines %q, expected %q", nonEmptyLines(lines), test.expectedLines)
+			}
+		})
+	}
+}
+
+func TestEmailLinesRejectsUnknownParsers(t *testing.T) {
+	_, err := emailLines(Email{Content: []byte("Subject: x\r\n\r\nbody")}, "unknown", nil)
+	if err == nil {
+		t.Error("no error for an unknown parser")
+	}
+}
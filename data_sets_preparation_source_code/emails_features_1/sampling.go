@@ -0,0 +1,173 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package emails_features_1
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+)
+
+import helpers "github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+
+const (
+	// The first emails of each mailbox, as the earlier versions selected them.
+	SamplingFirst = "first"
+
+	// Emails drawn at random, the same for the same seed and mailbox.
+	SamplingRandom = "random"
+)
+
+const (
+	// As many emails from each mailbox of a class as their numbers of emails allow.
+	AllocationEven = "even"
+
+	// Each mailbox of a class gives emails in proportion to its number of emails.
+	AllocationProportional = "proportional"
+)
+
+// How the emails of each class are taken from its mailboxes. The zero value selects as the earlier versions did.
+type Sampling struct {
+	// SamplingFirst (if empty) or SamplingRandom.
+	Method string
+
+	// The seed of SamplingRandom.
+	Seed int64
+
+	// AllocationEven (if empty) or AllocationProportional.
+	Allocation string
+}
+
+func (sampling Sampling) WithDefaults() (Sampling, error) {
+	if sampling.Method == "" {
+		sampling.Method = SamplingFirst
+	}
+	if sampling.Allocation == "" {
+		sampling.Allocation = AllocationEven
+	}
+
+	if sampling.Method != SamplingFirst && sampling.Method != SamplingRandom {
+		return sampling, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("unknown sampling %q (expected %s or %s)", sampling.Method, SamplingFirst, SamplingRandom)}
+	}
+	if sampling.Allocation != AllocationEven && sampling.Allocation != AllocationProportional {
+		return sampling, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("unknown mailbox allocation %q (expected %s or %s)", sampling.Allocation, AllocationEven, AllocationProportional)}
+	}
+	return sampling, nil
+}
+
+// Named as the parameters of the preparation, for the report.
+func (sampling Sampling) settings() map[string]interface{} {
+	settings := map[string]interface{}{
+		"sampling":           sampling.Method,
+		"mailbox_allocation": sampling.Allocation,
+	}
+	if sampling.Method == SamplingRandom {
+		settings["sampling_seed"] = sampling.Seed
+	}
+	return settings
+}
+
+func (sampling Sampling) quotas(emailsCounts []int, total int) []int {
+	if sampling.Allocation == AllocationProportional {
+		return proportionalQuotas(emailsCounts, total)
+	}
+	return evenQuotas(emailsCounts, total)
+}
+
+func (sampling Sampling) sampledEmails(mailboxPath string, emailsCount int, quota int) []bool {
+	isSampled := make([]bool, emailsCount)
+	if sampling.Method != SamplingRandom {
+		for emailNumber := 0; emailNumber < quota; emailNumber++ {
+			isSampled[emailNumber] = true
+		}
+		return isSampled
+	}
+
+	// Seeded by the mailbox too, so that it does not depend on the other mailboxes.
+	pathHash := fnv.New64a()
+	pathHash.Write([]byte(mailboxPath))
+	randomGenerator := rand.New(rand.NewSource(sampling.Seed ^ int64(pathHash.Sum64())))
+	for _, emailNumber := range randomGenerator.Perm(emailsCount)[:quota] {
+		isSampled[emailNumber] = true
+	}
+	return isSampled
+}
+
+// The earlier mailboxes get the remainders.
+func evenQuotas(emailsCounts []int, total int) []int {
+	quotas := make([]int, len(emailsCounts))
+	remaining := total
+	for remaining > 0 {
+		numberOfOpenMailboxes := 0
+		for i := range emailsCounts {
+			if quotas[i] < emailsCounts[i] {
+				numberOfOpenMailboxes++
+			}
+		}
+		if numberOfOpenMailboxes == 0 {
+			break
+		}
+
+		share := remaining / numberOfOpenMailboxes
+		if share == 0 {
+			share = 1
+		}
+		for i := range emailsCounts {
+			added := emailsCounts[i] - quotas[i]
+			if added > share {
+				added = share
+			}
+			if added > remaining {
+				added = remaining
+			}
+			quotas[i] += added
+			remaining -= added
+		}
+	}
+	return quotas
+}
+
+// The emails left over by rounding down go to the largest remainders, then to the earlier mailboxes.
+func proportionalQuotas(emailsCounts []int, total int) []int {
+	quotas := make([]int, len(emailsCounts))
+	totalEmailsCount := 0
+	for _, emailsCount := range emailsCounts {
+		totalEmailsCount += emailsCount
+	}
+	if totalEmailsCount == 0 {
+		return quotas
+	}
+
+	remainders := make([]int, len(emailsCounts))
+	remaining := total
+	for i, emailsCount := range emailsCounts {
+		quotas[i] = total * emailsCount / totalEmailsCount
+		remainders[i] = total * emailsCount % totalEmailsCount
+		remaining -= quotas[i]
+	}
+
+	order := make([]int, len(emailsCounts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+	for _, i := range order {
+		if remaining == 0 {
+			break
+		}
+		if quotas[i] < emailsCounts[i] {
+			quotas[i]++
+			remaining--
+		}
+	}
+	return quotas
+}
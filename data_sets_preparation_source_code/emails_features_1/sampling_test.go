@@ -0,0 +1,110 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package emails_features_1
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func sampledNumbers(isSampled []bool) []int {
+	numbers := []int{}
+	for emailNumber, sampled := range isSampled {
+		if sampled {
+			numbers = append(numbers, emailNumber)
+		}
+	}
+	return numbers
+}
+
+func TestSampledEmails(t *testing.T) {
+	first := Sampling{Method: SamplingFirst}
+	if numbers := sampledNumbers(first.sampledEmails("maildir/a/sent", 10, 4)); !reflect.DeepEqual(numbers, []int{0, 1, 2, 3}) {
+		t.Errorf("first sampling selected %v, expected the first 4 emails", numbers)
+	}
+
+	random := Sampling{Method: SamplingRandom, Seed: 7}
+	selection := sampledNumbers(random.sampledEmails("maildir/a/sent", 100, 10))
+	if len(selection) != 10 {
+		t.Fatalf("random sampling selected %d emails, expected 10", len(selection))
+	}
+	// Pinned, so that a data set sampled with a seed can be rebuilt by a later version.
+	if expected := []int{5, 11, 26, 33, 62, 63, 67, 73, 78, 89}; !reflect.DeepEqual(selection, expected) {
+		t.Errorf("seed 7 selected %v, expected %v", selection, expected)
+	}
+	if again := sampledNumbers(random.sampledEmails("maildir/a/sent", 100, 10)); !reflect.DeepEqual(again, selection) {
+		t.Errorf("the same seed and mailbox selected %v and then %v", selection, again)
+	}
+
+	otherSeed := Sampling{Method: SamplingRandom, Seed: 8}
+	if other := sampledNumbers(otherSeed.sampledEmails("maildir/a/sent", 100, 10)); reflect.DeepEqual(other, selection) {
+		t.Errorf("seeds 7 and 8 selected the same emails %v", selection)
+	}
+	if other := sampledNumbers(random.sampledEmails("maildir/b/sent", 100, 10)); reflect.DeepEqual(other, selection) {
+		t.Errorf("two mailboxes selected the same emails %v", selection)
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	tests := []struct {
+		name           string
+		allocation     string
+		emailsCounts   []int
+		total          int
+		expectedQuotas []int
+	}{
+		{name: "even, earlier mailboxes get the remainder", allocation: AllocationEven, emailsCounts: []int{10, 10, 10}, total: 10, expectedQuotas: []int{4, 3, 3}},
+		{name: "even, small mailboxes give what they have", allocation: AllocationEven, emailsCounts: []int{2, 10, 10}, total: 12, expectedQuotas: []int{2, 5, 5}},
+		{name: "even, a large mailbox makes up for a small one", allocation: AllocationEven, emailsCounts: []int{3, 100}, total: 50, expectedQuotas: []int{3, 47}},
+		{name: "proportional, exact shares", allocation: AllocationProportional, emailsCounts: []int{10, 30}, total: 20, expectedQuotas: []int{5, 15}},
+		{name: "proportional, largest remainder first", allocation: AllocationProportional, emailsCounts: []int{10, 20, 30}, total: 10, expectedQuotas: []int{2, 3, 5}},
+		{name: "proportional, equal remainders to the earlier mailboxes", allocation: AllocationProportional, emailsCounts: []int{1, 1, 1}, total: 2, expectedQuotas: []int{1, 1, 0}},
+		{name: "proportional, empty mailboxes", allocation: AllocationProportional, emailsCounts: []int{0, 0}, total: 0, expectedQuotas: []int{0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quotas := Sampling{Allocation: test.allocation}.quotas(test.emailsCounts, test.total)
+			if !reflect.DeepEqual(quotas, test.expectedQuotas) {
+				t.Errorf("quotas %v, expected %v", quotas, test.expectedQuotas)
+			}
+		})
+	}
+}
+
+func TestQuotasSumToTheTotalWithinTheMailboxes(t *testing.T) {
+	randomGenerator := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		emailsCounts := make([]int, 1+randomGenerator.Intn(6))
+		classEmailsCount := 0
+		for j := range emailsCounts {
+			emailsCounts[j] = randomGenerator.Intn(50)
+			classEmailsCount += emailsCounts[j]
+		}
+		total := 0
+		if classEmailsCount > 0 {
+			total = randomGenerator.Intn(classEmailsCount + 1)
+		}
+
+		for _, allocation := range []string{AllocationEven, AllocationProportional} {
+			quotas := Sampling{Allocation: allocation}.quotas(emailsCounts, total)
+			sum := 0
+			for j, quota := range quotas {
+				if quota < 0 || quota > emailsCounts[j] {
+					t.Fatalf("%s quotas %v of %d for the mailboxes %v are out of range", allocation, quotas, total, emailsCounts)
+				}
+				sum += quota
+			}
+			if sum != total {
+				t.Fatalf("%s quotas %v of %d for the mailboxes %v sum to %d", allocation, quotas, total, emailsCounts, sum)
+			}
+		}
+	}
+}
@@ -0,0 +1,404 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package emails_features_1
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+import helpers "github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+
+const (
+	SourceEnron   = "enron"
+	SourceMaildir = "maildir"
+	SourceMbox    = "mbox"
+	SourceEml     = "eml"
+)
+
+// Where the emails of a corpus are. The labels select the mailboxes of each class by name, path glob or regular expression (see parseLabels and matchClassMailboxes).
+type EmailSource interface {
+	// The mailboxes of the corpus, in the order they are considered for the labels.
+	Mailboxes(ctx context.Context, corpus fs.FS) ([]Mailbox, error)
+}
+
+type Mailbox interface {
+	// Matched by mailbox name patterns and globs without /.
+	Name() string
+
+	// Path of the mailbox in the corpus.
+	Path() string
+
+	NumberOfEmails() (int, error)
+
+	// Calls yield with the emails that isSelected returns true for (by number from 0), in order, until yield returns an error, which ReadEmails returns.
+	// The other emails are not read.
+	ReadEmails(ctx context.Context, isSelected func(emailNumber int) bool, yield func(email Email) error) error
+}
+
+// Names of the sources of the emails_features_1 preparation.
+var EmailSources = map[string]EmailSource{
+	SourceEnron:   EnronDirectories{},
+	SourceMaildir: MaildirFolders{},
+	SourceMbox:    MboxFiles{},
+	SourceEml:     EmlDirectories{},
+}
+
+func LookupEmailSource(name string) (EmailSource, error) {
+	source, isKnown := EmailSources[name]
+	if !isKnown {
+		return nil, fmt.Errorf("unknown email source %q (expected %s, %s, %s or %s)", name, SourceEnron, SourceMaildir, SourceMbox, SourceEml)
+	}
+	return source, nil
+}
+
+// Directories of one email per file, like the Enron maildir, each being a mailbox named as the directory.
+type EnronDirectories struct {
+}
+
+func (enronDirectories EnronDirectories) Mailboxes(ctx context.Context, corpus fs.FS) ([]Mailbox, error) {
+	return fileDirectoryMailboxes(ctx, corpus, func(name string) bool { return true })
+}
+
+// Directories of .eml files (one RFC 5322 message per file), each being a mailbox named as the directory. Other files are ignored.
+type EmlDirectories struct {
+}
+
+func (emlDirectories EmlDirectories) Mailboxes(ctx context.Context, corpus fs.FS) ([]Mailbox, error) {
+	return fileDirectoryMailboxes(ctx, corpus, isEmlFile)
+}
+
+func isEmlFile(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".eml")
+}
+
+type fileDirectoryMailbox struct {
+	corpus    fs.FS
+	directory string
+	isEmail   func(name string) bool
+}
+
+func fileDirectoryMailboxes(ctx context.Context, corpus fs.FS, isEmail func(name string) bool) ([]Mailbox, error) {
+	mailboxes := []Mailbox{}
+	isMailbox := make(map[string]bool)
+
+	err := fs.WalkDir(corpus, ".", func(filePath string, directoryEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return &helpers.PreparationError{Stage: "selecting emails", File: filePath, Err: err}
+		}
+		if ctx.Err() != nil {
+			return &helpers.PreparationError{Stage: "selecting emails", Err: ctx.Err()}
+		}
+
+		if !directoryEntry.IsDir() && isEmail(directoryEntry.Name()) && !isMailbox[path.Dir(filePath)] {
+			isMailbox[path.Dir(filePath)] = true
+			mailboxes = append(mailboxes, &fileDirectoryMailbox{corpus: corpus, directory: path.Dir(filePath), isEmail: isEmail})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mailboxes, nil
+}
+
+func (mailbox *fileDirectoryMailbox) Name() string {
+	return path.Base(mailbox.directory)
+}
+
+func (mailbox *fileDirectoryMailbox) Path() string {
+	return mailbox.directory
+}
+
+func (mailbox *fileDirectoryMailbox) emailFiles() ([]string, error) {
+	directoryEntries, err := fs.ReadDir(mailbox.corpus, mailbox.directory)
+	if err != nil {
+		return nil, &helpers.PreparationError{Stage: "selecting emails", File: mailbox.directory, Err: err}
+	}
+
+	emailFiles := []string{}
+	for _, directoryEntry := range directoryEntries {
+		if !directoryEntry.IsDir() && mailbox.isEmail(directoryEntry.Name()) {
+			emailFiles = append(emailFiles, path.Join(mailbox.directory, directoryEntry.Name()))
+		}
+	}
+	return emailFiles, nil
+}
+
+func (mailbox *fileDirectoryMailbox) NumberOfEmails() (int, error) {
+	emailFiles, err := mailbox.emailFiles()
+	return len(emailFiles), err
+}
+
+func (mailbox *fileDirectoryMailbox) ReadEmails(ctx context.Context, isSelected func(emailNumber int) bool, yield func(email Email) error) error {
+	emailFiles, err := mailbox.emailFiles()
+	if err != nil {
+		return err
+	}
+	return readEmailFiles(ctx, mailbox.corpus, emailFiles, isSelected, yield)
+}
+
+func readEmailFiles(ctx context.Context, corpus fs.FS, emailFiles []string, isSelected func(emailNumber int) bool, yield func(email Email) error) error {
+	for emailNumber, emailFile := range emailFiles {
+		if ctx.Err() != nil {
+			return &helpers.PreparationError{Stage: "selecting emails", Err: ctx.Err()}
+		}
+		if !isSelected(emailNumber) {
+			continue
+		}
+
+		content, err := fs.ReadFile(corpus, emailFile)
+		if err != nil {
+			return &helpers.PreparationError{Stage: "selecting emails", File: emailFile, Err: err}
+		}
+
+		err = yield(Email{Path: emailFile, Content: content})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Maildir folders (directories with cur and new), named without the leading dot of Maildir++ subfolders.
+// The emails are the files of cur and new, ordered by file name.
+type MaildirFolders struct {
+}
+
+type maildirFolder struct {
+	corpus    fs.FS
+	directory string
+}
+
+func (maildirFolders MaildirFolders) Mailboxes(ctx context.Context, corpus fs.FS) ([]Mailbox, error) {
+	mailboxes := []Mailbox{}
+
+	err := fs.WalkDir(corpus, ".", func(filePath string, directoryEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return &helpers.PreparationError{Stage: "selecting emails", File: filePath, Err: err}
+		}
+		if ctx.Err() != nil {
+			return &helpers.PreparationError{Stage: "selecting emails", Err: ctx.Err()}
+		}
+
+		if directoryEntry.IsDir() && isDirectory(corpus, path.Join(filePath, "cur")) && isDirectory(corpus, path.Join(filePath, "new")) {
+			mailboxes = append(mailboxes, &maildirFolder{corpus: corpus, directory: filePath})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mailboxes, nil
+}
+
+func isDirectory(corpus fs.FS, directory string) bool {
+	info, err := fs.Stat(corpus, directory)
+	return err == nil && info.IsDir()
+}
+
+// A maildir at the root of the corpus is named INBOX, as the root of a Maildir++ maildir is.
+func (mailbox *maildirFolder) Name() string {
+	if mailbox.directory == "." {
+		return "INBOX"
+	}
+	return strings.TrimPrefix(path.Base(mailbox.directory), ".")
+}
+
+func (mailbox *maildirFolder) Path() string {
+	return mailbox.directory
+}
+
+func (mailbox *maildirFolder) emailFiles() ([]string, error) {
+	emailFiles := []string{}
+	for _, subdirectory := range []string{"cur", "new"} {
+		directory := path.Join(mailbox.directory, subdirectory)
+		directoryEntries, err := fs.ReadDir(mailbox.corpus, directory)
+		if err != nil {
+			return nil, &helpers.PreparationError{Stage: "selecting emails", File: directory, Err: err}
+		}
+		for _, directoryEntry := range directoryEntries {
+			if !directoryEntry.IsDir() && !strings.HasPrefix(directoryEntry.Name(), ".") {
+				emailFiles = append(emailFiles, path.Join(directory, directoryEntry.Name()))
+			}
+		}
+	}
+
+	sort.SliceStable(emailFiles, func(i, j int) bool {
+		return path.Base(emailFiles[i]) < path.Base(emailFiles[j])
+	})
+	return emailFiles, nil
+}
+
+func (mailbox *maildirFolder) NumberOfEmails() (int, error) {
+	emailFiles, err := mailbox.emailFiles()
+	return len(emailFiles), err
+}
+
+func (mailbox *maildirFolder) ReadEmails(ctx context.Context, isSelected func(emailNumber int) bool, yield func(email Email) error) error {
+	emailFiles, err := mailbox.emailFiles()
+	if err != nil {
+		return err
+	}
+	return readEmailFiles(ctx, mailbox.corpus, emailFiles, isSelected, yield)
+}
+
+// mboxrd files, each being a mailbox named as the file without its .mbox extension.
+// Email paths are the file path, # and the message number from 1.
+type MboxFiles struct {
+}
+
+type mboxFile struct {
+	corpus fs.FS
+	file   string
+}
+
+func (mboxFiles MboxFiles) Mailboxes(ctx context.Context, corpus fs.FS) ([]Mailbox, error) {
+	mailboxes := []Mailbox{}
+
+	err := fs.WalkDir(corpus, ".", func(filePath string, directoryEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return &helpers.PreparationError{Stage: "selecting emails", File: filePath, Err: err}
+		}
+		if ctx.Err() != nil {
+			return &helpers.PreparationError{Stage: "selecting emails", Err: ctx.Err()}
+		}
+
+		if directoryEntry.IsDir() {
+			return nil
+		}
+		isMbox, err := isMboxFile(corpus, filePath)
+		if err != nil {
+			return &helpers.PreparationError{Stage: "selecting emails", File: filePath, Err: err}
+		}
+		if isMbox {
+			mailboxes = append(mailboxes, &mboxFile{corpus: corpus, file: filePath})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mailboxes, nil
+}
+
+// Files with the .mbox extension, and files starting with a "From " line.
+func isMboxFile(corpus fs.FS, filePath string) (bool, error) {
+	if strings.EqualFold(path.Ext(filePath), ".mbox") {
+		return true, nil
+	}
+
+	file, err := corpus.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	start := make([]byte, 5)
+	n, _ := file.Read(start)
+	return string(start[:n]) == "From ", nil
+}
+
+func (mailbox *mboxFile) Name() string {
+	name := path.Base(mailbox.file)
+	if strings.EqualFold(path.Ext(name), ".mbox") {
+		name = name[:len(name)-len(".mbox")]
+	}
+	return name
+}
+
+func (mailbox *mboxFile) Path() string {
+	return mailbox.file
+}
+
+// Calls read with each message that isSelected returns true for. Only those are kept in memory.
+func (mailbox *mboxFile) readMessages(isSelected func(messageNumber int) bool, read func(messageNumber int, message []byte) error) (numberOfMessages int, err error) {
+	file, err := mailbox.corpus.Open(mailbox.file)
+	if err != nil {
+		return 0, &helpers.PreparationError{Stage: "selecting emails", File: mailbox.file, Err: err}
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	messageNumber := -1
+	var message *bytes.Buffer
+	endMessage := func() error {
+		if message == nil {
+			return nil
+		}
+		content := message.Bytes()
+		message = nil
+		return read(messageNumber, content)
+	}
+
+	previousLineIsEmpty := true
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return 0, &helpers.PreparationError{Stage: "selecting emails", File: mailbox.file, Err: readErr}
+		}
+
+		// Text before the first message is skipped, so its last line need not be blank.
+		if (previousLineIsEmpty || messageNumber == -1) && bytes.HasPrefix(line, []byte("From ")) {
+			err = endMessage()
+			if err != nil {
+				return 0, err
+			}
+			messageNumber++
+			if isSelected(messageNumber) {
+				message = &bytes.Buffer{}
+			}
+			previousLineIsEmpty = false
+		} else if len(line) != 0 {
+			previousLineIsEmpty = len(bytes.TrimRight(line, "\r\n")) == 0
+			if message != nil {
+				unquotedLine := bytes.TrimLeft(line, ">")
+				if len(unquotedLine) != len(line) && bytes.HasPrefix(unquotedLine, []byte("From ")) {
+					line = line[1:]
+				}
+				message.Write(line)
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	err = endMessage()
+	if err != nil {
+		return 0, err
+	}
+	return messageNumber + 1, nil
+}
+
+func (mailbox *mboxFile) NumberOfEmails() (int, error) {
+	return mailbox.readMessages(func(messageNumber int) bool { return false }, nil)
+}
+
+func (mailbox *mboxFile) ReadEmails(ctx context.Context, isSelected func(emailNumber int) bool, yield func(email Email) error) error {
+	_, err := mailbox.readMessages(isSelected, func(messageNumber int, message []byte) error {
+		if ctx.Err() != nil {
+			return &helpers.PreparationError{Stage: "selecting emails", Err: ctx.Err()}
+		}
+		return yield(Email{Path: mailbox.file + "#" + strconv.Itoa(messageNumber+1), Content: message})
+	})
+	return err
+}
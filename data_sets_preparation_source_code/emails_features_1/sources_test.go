@@ -0,0 +1,192 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package emails_features_1
+
+import (
+	"context"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+type testMailbox struct {
+	name           string
+	path           string
+	numberOfEmails int
+	emailPaths     []string
+	contents       []string
+}
+
+func readTestMailboxes(t *testing.T, source EmailSource, corpus fs.FS, isSelected func(emailNumber int) bool) []testMailbox {
+	mailboxes, err := source.Mailboxes(context.Background(), corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := []testMailbox{}
+	for _, mailbox := range mailboxes {
+		numberOfEmails, err := mailbox.NumberOfEmails()
+		if err != nil {
+			t.Fatal(err)
+		}
+		read := testMailbox{name: mailbox.Name(), path: mailbox.Path(), numberOfEmails: numberOfEmails, emailPaths: []string{}, contents: []string{}}
+		err = mailbox.ReadEmails(context.Background(), isSelected, func(email Email) error {
+			read.emailPaths = append(read.emailPaths, email.Path)
+			read.contents = append(read.contents, string(email.Content))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, read)
+	}
+	return result
+}
+
+func allEmails(emailNumber int) bool {
+	return true
+}
+
+func TestEmailSources(t *testing.T) {
+	mbox := "text before the first message\n" +
+		"From alice@example.com Mon Jan  1 00:00:00 2001\n" +
+		"Subject: one\n" +
+		"\n" +
+		"body one\n" +
+		">From quoted\n" +
+		">>From quoted twice\n" +
+		"From not a separator, as the line before is not blank\n" +
+		"\n" +
+		"From bob@example.com Tue Jan  2 00:00:00 2001\r\n" +
+		"Subject: two\r\n" +
+		"\r\n" +
+		"body two"
+	messageOne := "Subject: one\n\nbody one\nFrom quoted\n>From quoted twice\nFrom not a separator, as the line before is not blank\n\n"
+	messageTwo := "Subject: two\r\n\r\nbody two"
+
+	tests := []struct {
+		name       string
+		source     EmailSource
+		corpus     fstest.MapFS
+		isSelected func(emailNumber int) bool
+		expected   []testMailbox
+	}{
+		{
+			name:   "mbox",
+			source: MboxFiles{},
+			corpus: fstest.MapFS{
+				"mail/inbox.mbox": {Data: []byte(mbox)},
+				"mail/archive":    {Data: []byte("From carol@example.com\nSubject: three\n\nbody three\n")},
+				"mail/notes.txt":  {Data: []byte("not an mbox\n")},
+			},
+			isSelected: allEmails,
+			expected: []testMailbox{
+				{name: "archive", path: "mail/archive", numberOfEmails: 1, emailPaths: []string{"mail/archive#1"}, contents: []string{"Subject: three\n\nbody three\n"}},
+				{name: "inbox", path: "mail/inbox.mbox", numberOfEmails: 2, emailPaths: []string{"mail/inbox.mbox#1", "mail/inbox.mbox#2"}, contents: []string{messageOne, messageTwo}},
+			},
+		},
+		{
+			name:       "mbox, selected emails only",
+			source:     MboxFiles{},
+			corpus:     fstest.MapFS{"inbox.mbox": {Data: []byte(mbox)}},
+			isSelected: func(emailNumber int) bool { return emailNumber == 1 },
+			expected: []testMailbox{
+				{name: "inbox", path: "inbox.mbox", numberOfEmails: 2, emailPaths: []string{"inbox.mbox#2"}, contents: []string{messageTwo}},
+			},
+		},
+		{
+			name:   "maildir",
+			source: MaildirFolders{},
+			corpus: fstest.MapFS{
+				"cur/1000.host:2,S":       {Data: []byte("cur email")},
+				"cur/.hidden":             {Data: []byte("hidden")},
+				"new/0500.host":           {Data: []byte("new email")},
+				"tmp/0700.host":           {Data: []byte("not delivered")},
+				".Sent/cur/0100.host:2,S": {Data: []byte("sent email")},
+				".Sent/new":               {Mode: fs.ModeDir},
+				"notes/cur/0200.host":     {Data: []byte("no new directory, not a maildir")},
+			},
+			isSelected: allEmails,
+			expected: []testMailbox{
+				{name: "INBOX", path: ".", numberOfEmails: 2, emailPaths: []string{"new/0500.host", "cur/1000.host:2,S"}, contents: []string{"new email", "cur email"}},
+				{name: "Sent", path: ".Sent", numberOfEmails: 1, emailPaths: []string{".Sent/cur/0100.host:2,S"}, contents: []string{"sent email"}},
+			},
+		},
+		{
+			name:   "maildir, selected emails only",
+			source: MaildirFolders{},
+			corpus: fstest.MapFS{
+				"Maildir/cur/1000.host": {Data: []byte("first")},
+				"Maildir/cur/2000.host": {Data: []byte("second")},
+				"Maildir/new/3000.host": {Data: []byte("third")},
+			},
+			isSelected: func(emailNumber int) bool { return emailNumber != 1 },
+			expected: []testMailbox{
+				{name: "Maildir", path: "Maildir", numberOfEmails: 3, emailPaths: []string{"Maildir/cur/1000.host", "Maildir/new/3000.host"}, contents: []string{"first", "third"}},
+			},
+		},
+		{
+			name:   "eml",
+			source: EmlDirectories{},
+			corpus: fstest.MapFS{
+				"eml/inbox/2.eml":      {Data: []byte("two")},
+				"eml/inbox/1.EML":      {Data: []byte("one")},
+				"eml/inbox/readme.txt": {Data: []byte("not an email")},
+				"eml/sent/a.eml":       {Data: []byte("a")},
+				"eml/other/readme.txt": {Data: []byte("no emails")},
+			},
+			isSelected: allEmails,
+			expected: []testMailbox{
+				{name: "inbox", path: "eml/inbox", numberOfEmails: 2, emailPaths: []string{"eml/inbox/1.EML", "eml/inbox/2.eml"}, contents: []string{"one", "two"}},
+				{name: "sent", path: "eml/sent", numberOfEmails: 1, emailPaths: []string{"eml/sent/a.eml"}, contents: []string{"a"}},
+			},
+		},
+		{
+			name:   "enron",
+			source: EnronDirectories{},
+			corpus: fstest.MapFS{
+				"maildir/allen-p/sent/1.": {Data: []byte("one")},
+				"maildir/allen-p/sent/2.": {Data: []byte("two")},
+				"maildir/allen-p/inbox/1": {Data: []byte("inbox")},
+			},
+			isSelected: allEmails,
+			expected: []testMailbox{
+				{name: "inbox", path: "maildir/allen-p/inbox", numberOfEmails: 1, emailPaths: []string{"maildir/allen-p/inbox/1"}, contents: []string{"inbox"}},
+				{name: "sent", path: "maildir/allen-p/sent", numberOfEmails: 2, emailPaths: []string{"maildir/allen-p/sent/1.", "maildir/allen-p/sent/2."}, contents: []string{"one", "two"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mailboxes := readTestMailboxes(t, test.source, test.corpus, test.isSelected)
+			if !reflect.DeepEqual(mailboxes, test.expected) {
+				t.Errorf("got mailboxes\n%+v\nexpected\n%+v", mailboxes, test.expected)
+			}
+		})
+	}
+}
+
+func TestReadEmailsStopsAtTheErrorOfYield(t *testing.T) {
+	corpus := fstest.MapFS{"inbox.mbox": {Data: []byte("From a\n\nbody a\n\nFrom b\n\nbody b\n")}}
+	mailboxes, err := MboxFiles{}.Mailboxes(context.Background(), corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numberOfEmails := 0
+	err = mailboxes[0].ReadEmails(context.Background(), allEmails, func(email Email) error {
+		numberOfEmails++
+		return errEnoughEmails
+	})
+	if err != errEnoughEmails || numberOfEmails != 1 {
+		t.Errorf("got %v after %d emails, expected errEnoughEmails after 1", err, numberOfEmails)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package emails_features_1
+
+import (
+	"fmt"
+)
+
+import helpers "github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+
+// The numbers the emails and the words of the features are selected by. Zero fields (0 on the command line too) take their values in DefaultThresholds.
+type Thresholds struct {
+	// A mailbox is used for a label only if it has at least this many emails.
+	MinimumEmailsPerLabel int
+
+	// The first this many emails of the mailbox of a label are selected.
+	MaximumEmailsPerLabel int
+
+	// Words contained in fewer emails are not features.
+	MinimumEmailsContainingWord int
+
+	// A word is in the top rankings of an email if its significance rank in the email is at most this.
+	TopRanks int
+
+	// Words in the top rankings of fewer emails are not features.
+	MinimumTopRankOccurrences int
+
+	// The most secondary features the emails can have together.
+	MaximumSecondaryFeatures int
+}
+
+// The thresholds the published data sets (such as MeeefTCD) were prepared with.
+var DefaultThresholds = Thresholds{
+	MinimumEmailsPerLabel:       300,
+	MaximumEmailsPerLabel:       300,
+	MinimumEmailsContainingWord: 10,
+	TopRanks:                    100,
+	MinimumTopRankOccurrences:   51,
+	MaximumSecondaryFeatures:    50000,
+}
+
+// The thresholds with the zero fields set to their defaults, or an error if they are negative or the maximum number of emails per label is less than the minimum.
+func (thresholds Thresholds) WithDefaults() (Thresholds, error) {
+	fields := []struct {
+		name         string
+		value        *int
+		defaultValue int
+	}{
+		{"minimum_emails_per_label", &thresholds.MinimumEmailsPerLabel, DefaultThresholds.MinimumEmailsPerLabel},
+		{"maximum_emails_per_label", &thresholds.MaximumEmailsPerLabel, DefaultThresholds.MaximumEmailsPerLabel},
+		{"minimum_emails_containing_word", &thresholds.MinimumEmailsContainingWord, DefaultThresholds.MinimumEmailsContainingWord},
+		{"top_ranks", &thresholds.TopRanks, DefaultThresholds.TopRanks},
+		{"minimum_top_rank_occurrences", &thresholds.MinimumTopRankOccurrences, DefaultThresholds.MinimumTopRankOccurrences},
+		{"maximum_secondary_features", &thresholds.MaximumSecondaryFeatures, DefaultThresholds.MaximumSecondaryFeatures},
+	}
+	for _, field := range fields {
+		if *field.value < 0 {
+			return thresholds, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("threshold %s is negative: %d", field.name, *field.value)}
+		}
+		if *field.value == 0 {
+			*field.value = field.defaultValue
+		}
+	}
+
+	if thresholds.MaximumEmailsPerLabel < thresholds.MinimumEmailsPerLabel {
+		return thresholds, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("threshold maximum_emails_per_label (%d) is less than minimum_emails_per_label (%d)", thresholds.MaximumEmailsPerLabel, thresholds.MinimumEmailsPerLabel)}
+	}
+	return thresholds, nil
+}
+
+// Named as the parameters of the preparation, for the report.
+func (thresholds Thresholds) settings() map[string]interface{} {
+	return map[string]interface{}{
+		"minimum_emails_per_label":       thresholds.MinimumEmailsPerLabel,
+		"maximum_emails_per_label":       thresholds.MaximumEmailsPerLabel,
+		"minimum_emails_containing_word": thresholds.MinimumEmailsContainingWord,
+		"top_ranks":                      thresholds.TopRanks,
+		"minimum_top_rank_occurrences":   thresholds.MinimumTopRankOccurrences,
+		"maximum_secondary_features":     thresholds.MaximumSecondaryFeatures,
+	}
+}
@@ -0,0 +1,161 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package emails_features_1
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+import helpers "github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+
+func TestDefaultThresholdsAreTheBaselineCuts(t *testing.T) {
+	// The baseline kept words in the top rankings of more than 50 emails.
+	expectedThresholds := Thresholds{
+		MinimumEmailsPerLabel:       300,
+		MaximumEmailsPerLabel:       300,
+		MinimumEmailsContainingWord: 10,
+		TopRanks:                    100,
+		MinimumTopRankOccurrences:   50 + 1,
+		MaximumSecondaryFeatures:    50000,
+	}
+	if DefaultThresholds != expectedThresholds {
+		t.Fatalf("DefaultThresholds = %+v, expected %+v", DefaultThresholds, expectedThresholds)
+	}
+}
+
+func TestThresholdsWithDefaults(t *testing.T) {
+	tests := []struct {
+		name               string
+		thresholds         Thresholds
+		expectedThresholds Thresholds
+		expectError        bool
+	}{
+		{name: "zero", thresholds: Thresholds{}, expectedThresholds: DefaultThresholds},
+		{
+			name:       "some fields set",
+			thresholds: Thresholds{MinimumEmailsPerLabel: 50, MaximumEmailsPerLabel: 100, TopRanks: 20},
+			expectedThresholds: Thresholds{
+				MinimumEmailsPerLabel:       50,
+				MaximumEmailsPerLabel:       100,
+				MinimumEmailsContainingWord: 10,
+				TopRanks:                    20,
+				MinimumTopRankOccurrences:   51,
+				MaximumSecondaryFeatures:    50000,
+			},
+		},
+		{name: "maximum equal to the minimum", thresholds: Thresholds{MinimumEmailsPerLabel: 10, MaximumEmailsPerLabel: 10}, expectedThresholds: Thresholds{10, 10, 10, 100, 51, 50000}},
+		{name: "maximum below the default minimum", thresholds: Thresholds{MaximumEmailsPerLabel: 100}, expectError: true},
+		{name: "maximum below the minimum", thresholds: Thresholds{MinimumEmailsPerLabel: 20, MaximumEmailsPerLabel: 10}, expectError: true},
+		{name: "negative minimum emails per label", thresholds: Thresholds{MinimumEmailsPerLabel: -1}, expectError: true},
+		{name: "negative maximum emails per label", thresholds: Thresholds{MaximumEmailsPerLabel: -300}, expectError: true},
+		{name: "negative minimum emails containing word", thresholds: Thresholds{MinimumEmailsContainingWord: -1}, expectError: true},
+		{name: "negative top ranks", thresholds: Thresholds{TopRanks: -1}, expectError: true},
+		{name: "negative minimum top rank occurrences", thresholds: Thresholds{MinimumTopRankOccurrences: -1}, expectError: true},
+		{name: "negative maximum secondary features", thresholds: Thresholds{MaximumSecondaryFeatures: -1}, expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thresholds, err := test.thresholds.WithDefaults()
+			if test.expectError {
+				var preparationError *helpers.PreparationError
+				if !errors.As(err, &preparationError) || preparationError.Stage != helpers.StageArguments {
+					t.Fatalf("expected an arguments error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if thresholds != test.expectedThresholds {
+				t.Fatalf("thresholds = %+v, expected %+v", thresholds, test.expectedThresholds)
+			}
+		})
+	}
+}
+
+// Two Enron mailboxes of 300 short emails of random words, the sent ones mostly of the first and the inbox ones of the last words of a vocabulary.
+// The word borderline is in 50 sent emails and the word borderlines in 51 inbox emails, all of their words being in their top rankings.
+func goldenCorpus() fstest.MapFS {
+	random := rand.New(rand.NewSource(1))
+	vocabulary := make([]string, 120)
+	for i := range vocabulary {
+		word := make([]byte, 6)
+		for j := range word {
+			word[j] = byte('a' + random.Intn(26))
+		}
+		vocabulary[i] = string(word)
+	}
+
+	corpus := fstest.MapFS{}
+	for mailboxNumber, mailbox := range []string{"sent", "inbox"} {
+		for emailNumber := 0; emailNumber < 300; emailNumber++ {
+			words := make([]string, 15)
+			for i := range words {
+				words[i] = vocabulary[mailboxNumber*40+random.Intn(80)]
+			}
+			if mailbox == "sent" && emailNumber < 50 || mailbox == "inbox" && emailNumber < 51 {
+				words = append(words, "borderline"+strings.Repeat("s", mailboxNumber))
+			}
+
+			content := fmt.Sprintf("Message-ID: <%d.%d.JavaMail.evans@thyme>\r\nSubject: %s\r\nX-FileName: golden.nsf\r\n\r\n%s\r\n", mailboxNumber, emailNumber, words[0], strings.Join(words, " "))
+			corpus[fmt.Sprintf("maildir/golden-u/%s/%d.", mailbox, emailNumber+1)] = &fstest.MapFile{Data: []byte(content)}
+		}
+	}
+	return corpus
+}
+
+// The hash of emails_features.csv that the baseline wrote for goldenCorpus (as a tar.gz input, with the parameters sent,inbox).
+const goldenFeaturesSha256 = "42303efcf0c70787ebbe03db9e88d96e4da7dfd43b7556d5d2d92c4348785b7d"
+
+func TestComputeFeaturesWithTheDefaultThresholdsIsTheBaseline(t *testing.T) {
+	console := bytes.Buffer{}
+	helpers.Log.SetConsole(&console)
+	defer helpers.Log.SetConsole(ioutil.Discard)
+
+	result, err := Compute(context.Background(), goldenCorpus(), Options{Labels: []string{"sent", "inbox"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if console.Len() != 0 {
+		t.Errorf("Compute without a logger printed %q", console.String())
+	}
+
+	isInVocabulary := make(map[string]bool)
+	for _, word := range result.Vocabulary {
+		isInVocabulary[word] = true
+	}
+	if isInVocabulary["borderline"] || !isInVocabulary["borderlines"] {
+		t.Errorf("expected borderlines and not borderline in the vocabulary %v", result.Vocabulary)
+	}
+	if !reflect.DeepEqual(result.LabelNames, []string{"sent", "inbox"}) || len(result.Features) != 600 {
+		t.Fatalf("expected 600 emails of the labels sent and inbox, got %d of %v", len(result.Features), result.LabelNames)
+	}
+
+	csv := bytes.Buffer{}
+	err = result.WriteCSV(&csv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256Sum := sha256.Sum256(csv.Bytes())
+	if hex.EncodeToString(sha256Sum[:]) != goldenFeaturesSha256 {
+		t.Fatalf("emails_features.csv has the sha256 %x, expected %s", sha256Sum, goldenFeaturesSha256)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type stringListFlag []string
+
+func (stringList *stringListFlag) String() string {
+	return strings.Join(*stringList, ",")
+}
+
+func (stringList *stringListFlag) Set(value string) error {
+	*stringList = append(*stringList, value)
+	return nil
+}
+
+type keyValueFlag map[string]string
+
+func (keyValue *keyValueFlag) String() string {
+	if keyValue == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*keyValue))
+	for key, value := range *keyValue {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (keyValue *keyValueFlag) Set(pair string) error {
+	separatorIndex := strings.LastIndex(pair, "=")
+	if separatorIndex <= 0 {
+		return fmt.Errorf("%q is not in the form <key>=<value>", pair)
+	}
+	(*keyValue)[pair[:separatorIndex]] = pair[separatorIndex+1:]
+	return nil
+}
+
+type byteSizeFlag int64
+
+var byteSizeSuffixes = []string{"K", "M", "G", "T"}
+
+func (byteSize *byteSizeFlag) String() string {
+	if byteSize == nil {
+		return ""
+	}
+	size := int64(*byteSize)
+	suffix := ""
+	for _, sizeSuffix := range byteSizeSuffixes {
+		if size == 0 || size%1024 != 0 {
+			break
+		}
+		size /= 1024
+		suffix = sizeSuffix
+	}
+	return strconv.FormatInt(size, 10) + suffix
+}
+
+func (byteSize *byteSizeFlag) Set(value string) error {
+	multiplier := int64(1)
+	number := strings.ToUpper(value)
+	for i, sizeSuffix := range byteSizeSuffixes {
+		if strings.HasSuffix(number, sizeSuffix) {
+			number = strings.TrimSuffix(number, sizeSuffix)
+			multiplier = int64(1) << (10 * (i + 1))
+			break
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 || size > math.MaxInt64/multiplier {
+		return fmt.Errorf("%q is not a size in bytes", value)
+	}
+	*byteSize = byteSizeFlag(size * multiplier)
+	return nil
+}
@@ -6,14 +6,21 @@
 	The purpose of writing this code is academic.
 */
 
+// Genome distances of the samples of the 1000 Genomes Project.
+//
+// ComputeDistances computes the distances in memory from the output of PLINK 2 in any fs.FS, writing them to disk being left to the WriteFile method.
 package genomes_distances
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"math"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
@@ -24,66 +31,100 @@ import (
 type GenomesDistancesPreparation1 struct {
 }
 
-func (genomesDistancesPreparation1 GenomesDistancesPreparation1) Prepare(dataSetPreparationInformation *helpers.DataSetPreparationInformation, outputDirectory string) {
-	plinkPath := filepath.Join(outputDirectory, "Uncompressed_downloaded_files", "plink2_win64_20220503", "plink2.exe")
-
-	fmt.Println(time.Now().Format(time.UnixDate))
-	fmt.Println("Running PLINK 2 ...")
+func (genomesDistancesPreparation1 GenomesDistancesPreparation1) Plan(dataSetPreparationInformation *helpers.DataSetPreparationInformation, outputDirectory string) helpers.PreparationPlan {
+	return helpers.PreparationPlan{
+		ExternalTools: []string{filepath.Join("Uncompressed_downloaded_files", "plink2_win64_20220503", "plink2.exe") + " --pfile genomes --make-rel square (30000 MB of memory)"},
+		FinalFiles:    []string{filepath.Join("Final_files", helpers.OutputFileName("distances", dataSetPreparationInformation.Options.OutputFormat))}}
+}
 
-	var debug bool = false
+func (genomesDistancesPreparation1 GenomesDistancesPreparation1) Prepare(ctx context.Context, dataSetPreparationInformation *helpers.DataSetPreparationInformation, outputDirectory string) error {
+	plinkPath := filepath.Join(outputDirectory, "Uncompressed_downloaded_files", "plink2_win64_20220503", "plink2.exe")
 
 	cmd := exec.Command(plinkPath, "--pfile", "genomes", "--make-rel", "square", "--out", "matrix", "--memory", "30000")
 
 	cmd.Dir = filepath.Join(outputDirectory, "Temporary_files")
 
-	if debug {
-		stdoutPipe, err := cmd.StdoutPipe()
-		if err != nil {
-			panic("Not finished successfully.")
-		}
-		stdoutPipeScanner := bufio.NewScanner(stdoutPipe)
-		go func() {
-			for stdoutPipeScanner.Scan() {
-				fmt.Println(stdoutPipeScanner.Text())
-			}
-		}()
-
-		stderrPipe, err := cmd.StderrPipe()
-		if err != nil {
-			panic("Not finished successfully.")
-		}
-		stderrPipeScanner := bufio.NewScanner(stderrPipe)
-		go func() {
-			for stderrPipeScanner.Scan() {
-				fmt.Println(stderrPipeScanner.Text())
-			}
-		}()
-	}
+	helpers.Log.Info(helpers.EventMessage, nil, time.Now().Format(time.UnixDate))
+	helpers.Log.Info("external_tool_started", helpers.LogFields{"tool": "plink2", "arguments": cmd.Args[1:]}, "Running PLINK 2 ...")
 
-	err := cmd.Start()
+	err := helpers.RunCommand(ctx, cmd, "plink2")
 	if err != nil {
-		panic("Not finished successfully.")
+		return &helpers.PreparationError{Stage: "running PLINK 2", File: plinkPath, Err: err}
 	}
 
-	err = cmd.Wait()
+	helpers.Log.Info("external_tool_finished", helpers.LogFields{"tool": "plink2"}, "Running PLINK 2 finished.")
+	helpers.Log.Info(helpers.EventMessage, nil, time.Now().Format(time.UnixDate))
+	dataSetPreparationInformation.Report.AddTool("plink2", plink2Version(ctx, plinkPath))
 
+	result, err := ComputeDistances(ctx, os.DirFS(outputDirectory), DistancesOptions{Log: helpers.Log})
 	if err != nil {
-		panic("Not finished successfully.")
+		// The files of the errors are relative to the output directory.
+		var preparationError *helpers.PreparationError
+		if errors.As(err, &preparationError) && preparationError.File != "" {
+			preparationError.File = filepath.Join(outputDirectory, filepath.FromSlash(preparationError.File))
+		}
+		return err
 	}
 
-	fmt.Println("Running PLINK 2 finished.")
-	fmt.Println(time.Now().Format(time.UnixDate))
+	return result.WriteFile(filepath.Join(outputDirectory, "Final_files", helpers.OutputFileName("distances", dataSetPreparationInformation.Options.OutputFormat)))
+}
+
+// Paths of the inputs of ComputeDistances in its file system. Empty paths are the ones in the output directory of genomes_preparation_1 after PLINK 2 has run, so that os.DirFS of that directory can be given as is.
+type DistancesOptions struct {
+	// The panel of the 1000 Genomes Project, the super population of a sample being its label.
+	PanelFile string
+
+	// The square relationship matrix written by PLINK 2 (--make-rel square) and its sample IDs.
+	MatrixFile    string
+	MatrixIDsFile string
+
+	// Where the statistics are logged, nothing being logged if nil. The preparation logs to helpers.Log.
+	Log *helpers.Logger
+}
+
+const (
+	DefaultPanelFile     = "Downloaded_files/integrated_call_samples_v3.20130502.ALL.panel"
+	DefaultMatrixFile    = "Temporary_files/matrix.rel"
+	DefaultMatrixIDsFile = "Temporary_files/matrix.rel.id"
+)
+
+// Super populations of the 1000 Genomes Project, the label number being the index.
+var LabelNames = []string{"EAS", "EUR", "AFR", "AMR", "SAS"}
+
+// The distances between the samples, each distance being the maximum relationship minus the relationship of the two samples.
+type DistancesResult struct {
+	SampleIDs []string
+
+	// Label numbers of the samples (indices of LabelNames). Samples missing from the panel have the label number 0 and the ones of other super populations -1.
+	Labels []int
+
+	Distances           [][]float64
+	MaximumRelationship float64
+
+	Statistics helpers.Statistics
+}
+
+// Reads the relationship matrix and the panel and computes the distances between the samples.
+func ComputeDistances(ctx context.Context, files fs.FS, options DistancesOptions) (*DistancesResult, error) {
+	if options.PanelFile == "" {
+		options.PanelFile = DefaultPanelFile
+	}
+	if options.MatrixFile == "" {
+		options.MatrixFile = DefaultMatrixFile
+	}
+	if options.MatrixIDsFile == "" {
+		options.MatrixIDsFile = DefaultMatrixIDsFile
+	}
 
 	labels := make(map[string]int)
-	labels["EAS"] = 1
-	labels["EUR"] = 2
-	labels["AFR"] = 3
-	labels["AMR"] = 4
-	labels["SAS"] = 5
+	for labelNumber, label := range LabelNames {
+		labels[label] = labelNumber + 1
+	}
 
-	labelsFileContent, err := ioutil.ReadFile(filepath.Join(outputDirectory, "Downloaded_files", "integrated_call_samples_v3.20130502.ALL.panel"))
+	labelsFile := options.PanelFile
+	labelsFileContent, err := fs.ReadFile(files, labelsFile)
 	if err != nil {
-		panic("Not finished successfully.")
+		return nil, &helpers.PreparationError{Stage: "reading labels", File: labelsFile, Err: err}
 	}
 	labelsFileLines := strings.Split(string(labelsFileContent), "\n")[1:]
 
@@ -95,12 +136,18 @@ func (genomesDistancesPreparation1 GenomesDistancesPreparation1) Prepare(dataSet
 			continue
 		}
 
-		labelNumbers[strings.Split(line, "\t")[0]] = labels[strings.Split(line, "\t")[2]] - 1
+		lineFields := strings.Split(line, "\t")
+		if len(lineFields) < 3 {
+			return nil, &helpers.PreparationError{Stage: "reading labels", File: labelsFile, Err: fmt.Errorf("line %q has less than 3 fields", line)}
+		}
+
+		labelNumbers[lineFields[0]] = labels[lineFields[2]] - 1
 	}
 
-	matrixIDsFileContent, err := ioutil.ReadFile(filepath.Join(outputDirectory, "Temporary_files", "matrix.rel.id"))
+	matrixIDsFile := options.MatrixIDsFile
+	matrixIDsFileContent, err := fs.ReadFile(files, matrixIDsFile)
 	if err != nil {
-		panic("Not finished successfully.")
+		return nil, &helpers.PreparationError{Stage: "reading matrix IDs", File: matrixIDsFile, Err: err}
 	}
 	matrixIDsLines := strings.Split(string(matrixIDsFileContent), "\n")[1:]
 
@@ -113,10 +160,10 @@ func (genomesDistancesPreparation1 GenomesDistancesPreparation1) Prepare(dataSet
 		}
 	}
 
-	matrixFile := filepath.Join(outputDirectory, "Temporary_files", "matrix.rel")
-	matrixFileContent, err := ioutil.ReadFile(matrixFile)
+	matrixFile := options.MatrixFile
+	matrixFileContent, err := fs.ReadFile(files, matrixFile)
 	if err != nil {
-		panic("Not finished successfully.")
+		return nil, &helpers.PreparationError{Stage: "reading matrix", File: matrixFile, Err: err}
 	}
 	matrixLines := strings.Split(string(matrixFileContent), "\n")
 	maximumFloat := -math.MaxFloat64
@@ -133,28 +180,131 @@ func (genomesDistancesPreparation1 GenomesDistancesPreparation1) Prepare(dataSet
 				continue
 			}
 
-			floatNumber, _ := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			floatNumber, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if err != nil {
+				return nil, &helpers.PreparationError{Stage: "reading matrix", File: matrixFile, Err: err}
+			}
 			maximumFloat = math.Max(maximumFloat, floatNumber)
-			floatNumbers[i] = append(floatNumbers[i], floatNumber)
+			floatNumbers[len(floatNumbers)-1] = append(floatNumbers[len(floatNumbers)-1], floatNumber)
 		}
 	}
 
-	distancesFile := filepath.Join(outputDirectory, "Final_files", "distances.csv")
-	distancesCSV := strings.Builder{}
+	if len(floatNumbers) != len(matrixIDs) {
+		return nil, &helpers.PreparationError{Stage: "reading matrix", File: matrixFile, Err: fmt.Errorf("matrix has %d rows but there are %d matrix IDs", len(floatNumbers), len(matrixIDs))}
+	}
+	for i := 0; i < len(floatNumbers); i++ {
+		if len(floatNumbers[i]) != len(floatNumbers) {
+			return nil, &helpers.PreparationError{Stage: "reading matrix", File: matrixFile, Err: fmt.Errorf("matrix row %d has %d columns but there are %d rows", i+1, len(floatNumbers[i]), len(floatNumbers))}
+		}
+	}
 
+	if ctx.Err() != nil {
+		return nil, &helpers.PreparationError{Stage: "computing distances", Err: ctx.Err()}
+	}
+
+	result := &DistancesResult{SampleIDs: matrixIDs, Labels: make([]int, len(matrixIDs)), MaximumRelationship: maximumFloat}
+	for i, matrixID := range matrixIDs {
+		result.Labels[i] = labelNumbers[matrixID]
+	}
+
+	result.Statistics.Add(options.Log, "number_of_samples", len(matrixIDs), nil, "Number of samples:", len(matrixIDs))
+	numberOfSamplesPerLabel := make(map[string]int)
+	for _, matrixID := range matrixIDs {
+		for label, labelNumber := range labels {
+			if labelNumbers[matrixID] == labelNumber-1 {
+				numberOfSamplesPerLabel[label]++
+			}
+		}
+	}
+	for _, label := range LabelNames {
+		result.Statistics.Add(options.Log, "number_of_samples_of_label", numberOfSamplesPerLabel[label], helpers.LogFields{"label": label, "label_number": labels[label] - 1},
+			"\t", label, "(Number:", labels[label]-1, ") , (Number of samples:", numberOfSamplesPerLabel[label], ")")
+	}
+
+	// Distances are the maximum relationship minus the relationship, so only the ones between different samples are summarized.
+	minimumDistance := math.MaxFloat64
+	maximumDistance := -math.MaxFloat64
+	var averageDistance float64 = 0
 	for i := 0; i < len(floatNumbers); i++ {
-		distancesCSV.WriteString(strconv.Itoa(labelNumbers[matrixIDs[i]]))
 		for j := 0; j < len(floatNumbers); j++ {
-			distancesCSV.WriteString(",")
-			distancesCSV.WriteString(strconv.FormatFloat(maximumFloat-floatNumbers[i][j], 'f', 10, 64))
+			if i == j {
+				continue
+			}
+			distance := maximumFloat - floatNumbers[i][j]
+			minimumDistance = math.Min(minimumDistance, distance)
+			maximumDistance = math.Max(maximumDistance, distance)
+			averageDistance += distance
+		}
+	}
+	if len(floatNumbers) > 1 {
+		averageDistance /= float64(len(floatNumbers) * (len(floatNumbers) - 1))
+		result.Statistics.Add(options.Log, "maximum_relationship", maximumFloat, nil, "Maximum relationship:", maximumFloat)
+		result.Statistics.Add(options.Log, "minimum_distance", minimumDistance, nil, "Minimum distance between different samples:", minimumDistance)
+		result.Statistics.Add(options.Log, "maximum_distance", maximumDistance, nil, "Maximum distance between different samples:", maximumDistance)
+		result.Statistics.Add(options.Log, "average_distance", averageDistance, nil, "Average distance between different samples:", averageDistance)
+	}
+
+	result.Distances = make([][]float64, len(floatNumbers))
+	for i := 0; i < len(floatNumbers); i++ {
+		result.Distances[i] = make([]float64, len(floatNumbers))
+		for j := 0; j < len(floatNumbers); j++ {
+			result.Distances[i][j] = maximumFloat - floatNumbers[i][j]
+		}
+	}
+
+	return result, nil
+}
+
+// Writes a line per sample of its label number followed by its distances, comma separated.
+func (result *DistancesResult) WriteCSV(writer io.Writer) error {
+	return result.WriteTable(writer, ",")
+}
+
+func (result *DistancesResult) WriteTable(writer io.Writer, separator string) error {
+	distancesCSV := strings.Builder{}
+
+	for i := 0; i < len(result.Distances); i++ {
+		distancesCSV.WriteString(strconv.Itoa(result.Labels[i]))
+		for j := 0; j < len(result.Distances[i]); j++ {
+			distancesCSV.WriteString(separator)
+			distancesCSV.WriteString(strconv.FormatFloat(result.Distances[i][j], 'f', 10, 64))
 		}
 		distancesCSV.WriteString("\r\n")
 	}
-	ioutil.WriteFile(distancesFile, []byte(distancesCSV.String()), 600)
+
+	_, err := io.WriteString(writer, distancesCSV.String())
+	return err
+}
+
+func (result *DistancesResult) WriteFile(distancesFile string) error {
+	distancesCSV := strings.Builder{}
+	err := result.WriteTable(&distancesCSV, helpers.OutputSeparatorOf(distancesFile))
+	if err != nil {
+		return &helpers.PreparationError{Stage: helpers.StageWritingFile, File: distancesFile, Err: err}
+	}
+
+	err = ioutil.WriteFile(distancesFile, []byte(distancesCSV.String()), helpers.FileMode())
+	if err != nil {
+		return &helpers.PreparationError{Stage: helpers.StageWritingFile, File: distancesFile, Err: err}
+	}
+
+	return nil
+}
+
+func init() {
+	helpers.RegisterPreparation(helpers.RegisteredPreparation{
+		Name:        "genomes_distances_1",
+		Description: "compute the genome distance matrix from the output of genomes_preparation_1",
+		NoInputs:    true,
+		Prepare: func(ctx context.Context, request *helpers.PreparationRequest) error {
+			return PrepareGenomeDistances1(ctx, request.OutputDirectory, request.Options)
+		},
+	})
 }
 
-func PrepareGenomeDistances1(outputDirectory string) {
+func PrepareGenomeDistances1(ctx context.Context, outputDirectory string, options helpers.PreparationOptions) error {
 	dataSetsPreparationInformation := new(helpers.DataSetPreparationInformation)
+	dataSetsPreparationInformation.Name = "genomes_distances_1"
 
 	dataSetsPreparationInformation.PrefixOfInputDownloadURLs = ""
 	dataSetsPreparationInformation.InputDownloadURLs = []string{}
@@ -162,6 +312,7 @@ func PrepareGenomeDistances1(outputDirectory string) {
 	dataSetsPreparationInformation.Parameters = nil
 	dataSetsPreparationInformation.Preparation = GenomesDistancesPreparation1{}
 	dataSetsPreparationInformation.OnlySpecificPreparation = true
+	dataSetsPreparationInformation.Options = options
 
-	dataSetsPreparationInformation.Prepare(outputDirectory)
+	return dataSetsPreparationInformation.Prepare(ctx, outputDirectory)
 }
@@ -9,18 +9,27 @@
 package genomes_distances
 
 import (
-	"bufio"
-	"fmt"
+	"context"
 	"github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 type GenomesPreparation1 struct {
 }
 
-func (genomesPreparation1 GenomesPreparation1) Prepare(dataSetPreparationInformation *helpers.DataSetPreparationInformation, outputDirectory string) {
+func (genomesPreparation1 GenomesPreparation1) Plan(dataSetPreparationInformation *helpers.DataSetPreparationInformation, outputDirectory string) helpers.PreparationPlan {
+	return helpers.PreparationPlan{
+		ExternalTools: []string{filepath.Join("Uncompressed_downloaded_files", "plink2_win64_20220503", "plink2.exe") + " --make-pgen --bcf " + dataSetPreparationInformation.InputDownloadURLs[0] + " (30000 MB of memory)"},
+		FinalFiles: []string{
+			filepath.Join("Temporary_files", "genomes.pgen"),
+			filepath.Join("Temporary_files", "genomes.pvar"),
+			filepath.Join("Temporary_files", "genomes.psam")}}
+}
+
+func (genomesPreparation1 GenomesPreparation1) Prepare(ctx context.Context, dataSetPreparationInformation *helpers.DataSetPreparationInformation, outputDirectory string) error {
 	plinkPath := filepath.Join(outputDirectory, "Uncompressed_downloaded_files", "plink2_win64_20220503", "plink2.exe")
 	cmd := exec.Command(plinkPath,
 		"--make-pgen",
@@ -37,69 +46,83 @@ func (genomesPreparation1 GenomesPreparation1) Prepare(dataSetPreparationInforma
 
 	cmd.Dir = filepath.Join(outputDirectory, "Temporary_files")
 
-	fmt.Println(time.Now().Format(time.UnixDate))
-	fmt.Println("Running PLINK 2 ...")
-
-	var debug bool = false
+	helpers.Log.Info(helpers.EventMessage, nil, time.Now().Format(time.UnixDate))
+	helpers.Log.Info("external_tool_started", helpers.LogFields{"tool": "plink2", "arguments": cmd.Args[1:]}, "Running PLINK 2 ...")
 
-	if debug {
-		stdoutPipe, err := cmd.StdoutPipe()
-		if err != nil {
-			panic("Not finished successfully.")
-		}
-		stdoutPipeScanner := bufio.NewScanner(stdoutPipe)
-		go func() {
-			for stdoutPipeScanner.Scan() {
-				fmt.Println(stdoutPipeScanner.Text())
-			}
-		}()
-
-		stderrPipe, err := cmd.StderrPipe()
-		if err != nil {
-			panic("Not finished successfully.")
-		}
-		stderrPipeScanner := bufio.NewScanner(stderrPipe)
-		go func() {
-			for stderrPipeScanner.Scan() {
-				fmt.Println(stderrPipeScanner.Text())
-			}
-		}()
-	}
-
-	err := cmd.Start()
+	err := helpers.RunCommand(ctx, cmd, "plink2")
 	if err != nil {
-		panic("Not finished successfully.")
+		return &helpers.PreparationError{Stage: "running PLINK 2", File: plinkPath, Err: err}
 	}
 
-	err = cmd.Wait()
+	helpers.Log.Info("external_tool_finished", helpers.LogFields{"tool": "plink2"}, "Running PLINK 2 finished.")
+	helpers.Log.Info(helpers.EventMessage, nil, time.Now().Format(time.UnixDate))
+	dataSetPreparationInformation.Report.AddTool("plink2", plink2Version(ctx, plinkPath))
+	return nil
+}
 
-	if err != nil {
-		panic("Not finished successfully.")
+// The first line PLINK 2 prints for --version, or the name of its directory if it cannot be run for that.
+func plink2Version(ctx context.Context, plinkPath string) string {
+	output, err := exec.CommandContext(ctx, plinkPath, "--version").Output()
+	if err != nil || len(strings.TrimSpace(string(output))) == 0 {
+		return filepath.Base(filepath.Dir(plinkPath))
 	}
+	return strings.TrimSpace(strings.SplitN(strings.TrimSpace(string(output)), "\n", 2)[0])
+}
 
-	fmt.Println("Running PLINK 2 finished.")
-	fmt.Println(time.Now().Format(time.UnixDate))
+const DefaultPrefixOfGenomesInputDownloadURLs1 = "https://ftp-trace.ncbi.nih.gov/1000genomes/ftp/release/20130502/"
+
+// The inputs are fixed since the preparation refers to them by position and by the uncompressed PLINK 2 directory name.
+var GenomesInputDownloadURLs1 = []string{
+	"supporting/bcf_files/ALL.wgs.phase3_shapeit2_mvncall_integrated_v5.20130502.genotypes.bcf",
+	"supporting/bcf_files/ALL.wgs.phase3_shapeit2_mvncall_integrated_v5.20130502.genotypes.bcf.csi",
+	"integrated_call_samples_v3.20130502.ALL.panel",
+	"https://s3.amazonaws.com/plink2-assets/plink2_win64_20220503.zip",
+	"https://www.cog-genomics.org/static/bin/plink2_src_220503.zip"}
+
+func init() {
+	helpers.RegisterPreparation(helpers.RegisteredPreparation{
+		Name:                             "genomes_preparation_1",
+		Description:                      "download the 1000 Genomes phase 3 genotypes and prepare them with PLINK 2",
+		DefaultPrefixOfInputDownloadURLs: DefaultPrefixOfGenomesInputDownloadURLs1,
+		DefaultInputDownloadURLs:         GenomesInputDownloadURLs1,
+		FixedInputDownloadURLs:           true,
+		Prepare: func(ctx context.Context, request *helpers.PreparationRequest) error {
+			return PrepareGenomes1(ctx, request.OutputDirectory, request.PrefixOfInputDownloadURLs, request.Options)
+		},
+	})
+
+	helpers.RegisterPreparation(helpers.RegisteredPreparation{
+		Name:                             "genomes_preparation_and_distances_1",
+		Description:                      "run genomes_preparation_1 and then genomes_distances_1 in the same output directory",
+		DefaultPrefixOfInputDownloadURLs: DefaultPrefixOfGenomesInputDownloadURLs1,
+		DefaultInputDownloadURLs:         GenomesInputDownloadURLs1,
+		FixedInputDownloadURLs:           true,
+		Prepare: func(ctx context.Context, request *helpers.PreparationRequest) error {
+			err := PrepareGenomes1(ctx, request.OutputDirectory, request.PrefixOfInputDownloadURLs, request.Options)
+			if err != nil {
+				return err
+			}
+			return PrepareGenomeDistances1(ctx, request.OutputDirectory, request.Options)
+		},
+	})
 }
 
-func PrepareGenomes1(outputDirectory string, prefixOfInputDownloadURLs interface{}) {
+func PrepareGenomes1(ctx context.Context, outputDirectory string, prefixOfInputDownloadURLs interface{}, options helpers.PreparationOptions) error {
 	dataSetsPreparationInformation := new(helpers.DataSetPreparationInformation)
+	dataSetsPreparationInformation.Name = "genomes_preparation_1"
 
 	if prefixOfInputDownloadURLs == nil {
-		dataSetsPreparationInformation.PrefixOfInputDownloadURLs = "https://ftp-trace.ncbi.nih.gov/1000genomes/ftp/release/20130502/"
+		dataSetsPreparationInformation.PrefixOfInputDownloadURLs = DefaultPrefixOfGenomesInputDownloadURLs1
 	} else {
 		dataSetsPreparationInformation.PrefixOfInputDownloadURLs = prefixOfInputDownloadURLs.(string)
 	}
 
-	dataSetsPreparationInformation.InputDownloadURLs = []string{
-		"supporting/bcf_files/ALL.wgs.phase3_shapeit2_mvncall_integrated_v5.20130502.genotypes.bcf",
-		"supporting/bcf_files/ALL.wgs.phase3_shapeit2_mvncall_integrated_v5.20130502.genotypes.bcf.csi",
-		"integrated_call_samples_v3.20130502.ALL.panel",
-		"https://s3.amazonaws.com/plink2-assets/plink2_win64_20220503.zip",
-		"https://www.cog-genomics.org/static/bin/plink2_src_220503.zip"}
+	dataSetsPreparationInformation.InputDownloadURLs = GenomesInputDownloadURLs1
 
 	dataSetsPreparationInformation.Parameters = nil
 	dataSetsPreparationInformation.Preparation = GenomesPreparation1{}
 	//dataSetsPreparationInformation.OnlySpecificPreparation = true
+	dataSetsPreparationInformation.Options = options
 
-	dataSetsPreparationInformation.Prepare(outputDirectory)
+	return dataSetsPreparationInformation.Prepare(ctx, outputDirectory)
 }
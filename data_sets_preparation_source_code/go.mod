@@ -2,4 +2,9 @@ module github.com/farshad-barahimi-academic-codes/data_sets_preparation
 
 go 1.18
 
-require github.com/emirpasic/gods v1.18.1 // indirect
+require (
+	github.com/emirpasic/gods v1.18.1
+	github.com/ulikunitz/xz v0.5.9
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
+)
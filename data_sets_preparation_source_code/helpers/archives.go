@@ -0,0 +1,281 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	compressionNone  = ""
+	compressionGzip  = "gzip"
+	compressionBzip2 = "bzip2"
+	compressionXz    = "xz"
+)
+
+// An archive format is detected by the file extension, then confirmed or corrected by the magic bytes.
+type ArchiveFormat struct {
+	Name              string
+	Extensions        []string
+	MatchesMagicBytes func(header []byte) bool
+	Uncompress        func(ctx context.Context, compressedFile string, uncompressedDirectory string, options PreparationOptions) error
+
+	// Rough uncompressed to archive size ratio, for dry runs (0 is taken as 1).
+	UncompressedSizeRatio float64
+}
+
+const compressedTextSizeRatio = 4
+
+var archiveFormatsMutex sync.Mutex
+var archiveFormats = []ArchiveFormat{
+	newTarArchiveFormat("tar.gz", []string{".tar.gz", ".tgz"}, compressionGzip),
+	newTarArchiveFormat("tar.bz2", []string{".tar.bz2", ".tbz2", ".tbz"}, compressionBzip2),
+	newTarArchiveFormat("tar.xz", []string{".tar.xz", ".txz"}, compressionXz),
+	newTarArchiveFormat("tar", []string{".tar"}, compressionNone),
+	{
+		Name:                  "zip",
+		Extensions:            []string{".zip"},
+		MatchesMagicBytes:     isZipHeader,
+		Uncompress:            uncompressZip,
+		UncompressedSizeRatio: compressedTextSizeRatio,
+	},
+	newCompressedFileArchiveFormat("gz", []string{".gz"}, compressionGzip),
+	newCompressedFileArchiveFormat("bz2", []string{".bz2"}, compressionBzip2),
+	newCompressedFileArchiveFormat("xz", []string{".xz"}, compressionXz),
+}
+
+var tarArchiveFormatNames = map[string]string{
+	compressionGzip:  "tar.gz",
+	compressionBzip2: "tar.bz2",
+	compressionXz:    "tar.xz",
+}
+
+func RegisterArchiveFormat(archiveFormat ArchiveFormat) {
+	archiveFormatsMutex.Lock()
+	defer archiveFormatsMutex.Unlock()
+
+	archiveFormats = append([]ArchiveFormat{archiveFormat}, archiveFormats...)
+}
+
+func newTarArchiveFormat(name string, extensions []string, compression string) ArchiveFormat {
+	return ArchiveFormat{
+		Name:       name,
+		Extensions: extensions,
+		MatchesMagicBytes: func(header []byte) bool {
+			if compression == compressionNone {
+				return isTarHeader(header)
+			}
+			return compressionOfHeader(header) == compression
+		},
+		Uncompress: func(ctx context.Context, compressedFile string, uncompressedDirectory string, options PreparationOptions) error {
+			return uncompressTar(ctx, compressedFile, compression, uncompressedDirectory, options)
+		},
+		UncompressedSizeRatio: uncompressedSizeRatio(compression),
+	}
+}
+
+func uncompressedSizeRatio(compression string) float64 {
+	if compression == compressionNone {
+		return 1
+	}
+	return compressedTextSizeRatio
+}
+
+func newCompressedFileArchiveFormat(name string, extensions []string, compression string) ArchiveFormat {
+	return ArchiveFormat{
+		Name:       name,
+		Extensions: extensions,
+		MatchesMagicBytes: func(header []byte) bool {
+			return compressionOfHeader(header) == compression
+		},
+		Uncompress: func(ctx context.Context, compressedFile string, uncompressedDirectory string, options PreparationOptions) error {
+			return uncompressCompressedFile(ctx, compressedFile, compression, uncompressedDirectory, options)
+		},
+		UncompressedSizeRatio: uncompressedSizeRatio(compression),
+	}
+}
+
+func archiveFormatByName(name string) *ArchiveFormat {
+	archiveFormatsMutex.Lock()
+	defer archiveFormatsMutex.Unlock()
+
+	for _, archiveFormat := range archiveFormats {
+		if archiveFormat.Name == name {
+			return &archiveFormat
+		}
+	}
+	return nil
+}
+
+// The longest matching extension wins, so that "x.tar.gz" is a tar.gz archive rather than a gz file.
+func archiveFormatByExtension(filePath string) (archiveFormat *ArchiveFormat, extension string) {
+	archiveFormatsMutex.Lock()
+	defer archiveFormatsMutex.Unlock()
+
+	lowerCaseFilePath := strings.ToLower(filePath)
+	for i := range archiveFormats {
+		for _, formatExtension := range archiveFormats[i].Extensions {
+			if strings.HasSuffix(lowerCaseFilePath, strings.ToLower(formatExtension)) && len(formatExtension) > len(extension) {
+				matchedFormat := archiveFormats[i]
+				archiveFormat = &matchedFormat
+				extension = filePath[len(filePath)-len(formatExtension):]
+			}
+		}
+	}
+	return archiveFormat, extension
+}
+
+// Files without an archive extension are never uncompressed, even if compressed (such as BGZF BCF files).
+func detectArchiveFormat(filePath string) (archiveFormat *ArchiveFormat, extension string, err error) {
+	archiveFormat, extension = archiveFormatByExtension(filePath)
+	if archiveFormat == nil {
+		return nil, "", nil
+	}
+
+	header, err := readFileHeader(filePath, 512)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if archiveFormat.MatchesMagicBytes == nil || !archiveFormat.MatchesMagicBytes(header) {
+		matchedFormat := archiveFormatByMagicBytes(header)
+		if matchedFormat == nil {
+			return nil, "", fmt.Errorf("file content does not match the %s archive format", archiveFormat.Name)
+		}
+		Log.Warning("archive_format_mismatch", LogFields{"file": filePath, "extension_format": archiveFormat.Name, "content_format": matchedFormat.Name}, "| File content is a", matchedFormat.Name, "archive rather than a", archiveFormat.Name, "archive", filepath.Base(filePath))
+		archiveFormat = matchedFormat
+	}
+
+	compression := compressionOfHeader(header)
+	tarArchiveFormatName := tarArchiveFormatNames[compression]
+	if compression != compressionNone && archiveFormat.Name != tarArchiveFormatName {
+		isTar, err := isCompressedTar(filePath, compression)
+		if err != nil {
+			return nil, "", err
+		}
+		if isTar {
+			archiveFormat = archiveFormatByName(tarArchiveFormatName)
+		}
+	}
+
+	return archiveFormat, extension, nil
+}
+
+func archiveFormatByMagicBytes(header []byte) *ArchiveFormat {
+	archiveFormatsMutex.Lock()
+	defer archiveFormatsMutex.Unlock()
+
+	for _, archiveFormat := range archiveFormats {
+		if archiveFormat.MatchesMagicBytes != nil && archiveFormat.MatchesMagicBytes(header) {
+			return &archiveFormat
+		}
+	}
+	return nil
+}
+
+func readFileHeader(filePath string, size int) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, size)
+	readSize, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return header[:readSize], nil
+}
+
+func isCompressedTar(filePath string, compression string) (bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	decompressedReader, err := decompress(compression, file)
+	if err != nil {
+		return false, err
+	}
+
+	header := make([]byte, 512)
+	readSize, err := io.ReadFull(decompressedReader, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return isTarHeader(header[:readSize]), nil
+}
+
+func compressionOfHeader(header []byte) string {
+	if bytes.HasPrefix(header, []byte{0x1f, 0x8b}) {
+		return compressionGzip
+	} else if bytes.HasPrefix(header, []byte("BZh")) {
+		return compressionBzip2
+	} else if bytes.HasPrefix(header, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}) {
+		return compressionXz
+	}
+	return compressionNone
+}
+
+func isZipHeader(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06"))
+}
+
+// Old (pre-POSIX) tar headers have no "ustar" magic, so the header checksum is checked as well.
+func isTarHeader(header []byte) bool {
+	if len(header) < 512 {
+		return false
+	}
+	if bytes.Equal(header[257:262], []byte("ustar")) {
+		return true
+	}
+
+	recordedChecksum, err := strconv.ParseInt(strings.Trim(string(header[148:156]), " \x00"), 8, 64)
+	if err != nil {
+		return false
+	}
+	var checksum int64 = 0
+	for i, headerByte := range header[:512] {
+		if i >= 148 && i < 156 {
+			headerByte = ' '
+		}
+		checksum += int64(headerByte)
+	}
+	return checksum == recordedChecksum
+}
+
+func decompress(compression string, reader io.Reader) (io.Reader, error) {
+	switch compression {
+	case compressionNone:
+		return reader, nil
+	case compressionGzip:
+		return gzip.NewReader(reader)
+	case compressionBzip2:
+		return bzip2.NewReader(reader), nil
+	case compressionXz:
+		return xz.NewReader(bufio.NewReader(reader))
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+}
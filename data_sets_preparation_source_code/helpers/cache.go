@@ -0,0 +1,344 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const CacheDirectoryEnvironmentVariable = "DATA_SETS_PREPARATION_CACHE"
+
+// Prune leaves younger temporary files of add alone, as another run may still be writing them.
+const abandonedTemporaryObjectAge = 24 * time.Hour
+
+// Downloaded files are stored once under objects/<sha256> and every URL has an entry under urls/<sha256 of the URL>.json pointing to its object.
+type DownloadCache struct {
+	Directory string
+}
+
+type CacheEntry struct {
+	URL        string    `json:"url"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	MD5        string    `json:"md5"`
+	AddedAt    time.Time `json:"added_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+func OpenDownloadCache(directory string) (*DownloadCache, error) {
+	cache := &DownloadCache{Directory: directory}
+
+	for _, cacheDirectory := range []string{cache.objectsDirectory(), cache.urlsDirectory()} {
+		err := os.MkdirAll(cacheDirectory, DirectoryMode())
+		if err != nil {
+			return nil, &PreparationError{Stage: StageSetup, File: cacheDirectory, Err: err}
+		}
+	}
+
+	return cache, nil
+}
+
+func (cache *DownloadCache) objectsDirectory() string {
+	return filepath.Join(cache.Directory, "objects")
+}
+
+func (cache *DownloadCache) urlsDirectory() string {
+	return filepath.Join(cache.Directory, "urls")
+}
+
+func (cache *DownloadCache) objectPath(sha256Digest string) string {
+	return filepath.Join(cache.objectsDirectory(), sha256Digest)
+}
+
+func (cache *DownloadCache) entryPath(url string) string {
+	urlHash := sha256.Sum256([]byte(url))
+	return filepath.Join(cache.urlsDirectory(), hex.EncodeToString(urlHash[:])+".json")
+}
+
+func (cache *DownloadCache) readEntry(entryPath string) (*CacheEntry, error) {
+	content, err := ioutil.ReadFile(entryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &CacheEntry{}
+	err = json.Unmarshal(content, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (cache *DownloadCache) writeEntry(entry *CacheEntry) error {
+	content, err := json.MarshalIndent(entry, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(cache.entryPath(entry.URL), content)
+}
+
+// Returns the entry of a complete cached file without using it, or nil (also for a nil cache).
+func (cache *DownloadCache) entryOf(url string) *CacheEntry {
+	if cache == nil {
+		return nil
+	}
+	entry, err := cache.readEntry(cache.entryPath(url))
+	if err != nil {
+		return nil
+	}
+	objectInfo, err := os.Stat(cache.objectPath(entry.SHA256))
+	if err != nil || objectInfo.Size() != entry.Size {
+		return nil
+	}
+	return entry
+}
+
+// A missing, incomplete or mismatching cached file is a cache miss.
+func (cache *DownloadCache) linkInto(url string, checksum string, filePath string) (*ManifestEntry, error) {
+	entry, err := cache.readEntry(cache.entryPath(url))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, &PreparationError{Stage: StageDownload, URL: url, File: cache.entryPath(url), Err: err}
+	}
+
+	objectPath := cache.objectPath(entry.SHA256)
+	objectInfo, err := os.Stat(objectPath)
+	if err != nil || objectInfo.Size() != entry.Size {
+		Log.Warning("cached_file_invalid", LogFields{"url": url, "object": objectPath}, "| Cached file is missing or incomplete, downloading again", url)
+		return nil, nil
+	}
+
+	manifestEntry := &ManifestEntry{URL: url, Size: entry.Size, SHA256: entry.SHA256, MD5: entry.MD5}
+	if checksum != "" && manifestEntry.verify(checksum) != nil {
+		Log.Warning("cached_file_invalid", LogFields{"url": url, "object": objectPath, "checksum": checksum}, "| Cached file does not match the declared checksum, downloading again", url)
+		return nil, nil
+	}
+
+	err = os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, &PreparationError{Stage: StageDownload, URL: url, File: filePath, Err: err}
+	}
+
+	err = linkOrCopyFile(objectPath, filePath)
+	if err != nil {
+		return nil, &PreparationError{Stage: StageDownload, URL: url, File: filePath, Err: err}
+	}
+
+	entry.LastUsedAt = time.Now().UTC()
+	err = cache.writeEntry(entry)
+	if err != nil {
+		return nil, &PreparationError{Stage: StageDownload, URL: url, File: cache.entryPath(url), Err: err}
+	}
+
+	Log.Info("using_cached_file", LogFields{"url": url, "file": filePath, "size": entry.Size, "sha256": entry.SHA256}, "Using cached file...", url, "| Size~= ", entry.Size/1024, "KB")
+	Log.EmptyLine()
+	return manifestEntry, nil
+}
+
+func (cache *DownloadCache) add(manifestEntry ManifestEntry, filePath string) error {
+	objectPath := cache.objectPath(manifestEntry.SHA256)
+
+	_, err := os.Stat(objectPath)
+	if os.IsNotExist(err) {
+		temporaryObjectPath := objectPath + "." + strconv.FormatInt(time.Now().UnixNano(), 10) + ".tmp"
+		err = os.Remove(temporaryObjectPath)
+		if err != nil && !os.IsNotExist(err) {
+			return &PreparationError{Stage: StageDownload, URL: manifestEntry.URL, File: temporaryObjectPath, Err: err}
+		}
+		err = linkOrCopyFile(filePath, temporaryObjectPath)
+		if err != nil {
+			return &PreparationError{Stage: StageDownload, URL: manifestEntry.URL, File: temporaryObjectPath, Err: err}
+		}
+		err = os.Rename(temporaryObjectPath, objectPath)
+	}
+	if err != nil {
+		return &PreparationError{Stage: StageDownload, URL: manifestEntry.URL, File: objectPath, Err: err}
+	}
+
+	now := time.Now().UTC()
+	err = cache.writeEntry(&CacheEntry{
+		URL:        manifestEntry.URL,
+		Size:       manifestEntry.Size,
+		SHA256:     manifestEntry.SHA256,
+		MD5:        manifestEntry.MD5,
+		AddedAt:    now,
+		LastUsedAt: now,
+	})
+	if err != nil {
+		return &PreparationError{Stage: StageDownload, URL: manifestEntry.URL, File: cache.entryPath(manifestEntry.URL), Err: err}
+	}
+
+	return nil
+}
+
+func (cache *DownloadCache) Entries() ([]CacheEntry, error) {
+	entryPaths, err := filepath.Glob(filepath.Join(cache.urlsDirectory(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]CacheEntry, 0, len(entryPaths))
+	for _, entryPath := range entryPaths {
+		entry, err := cache.readEntry(entryPath)
+		if err != nil {
+			return nil, &PreparationError{Stage: "reading cache", File: entryPath, Err: err}
+		}
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i int, j int) bool {
+		return entries[i].URL < entries[j].URL
+	})
+
+	return entries, nil
+}
+
+// Verify re-hashes every cached object and returns a description of every problem found; corrupt objects are removed when removeCorrupt is set.
+func (cache *DownloadCache) Verify(removeCorrupt bool) ([]string, error) {
+	problems := make([]string, 0)
+
+	entries, err := cache.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		_, err := os.Stat(cache.objectPath(entry.SHA256))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: cached file %s is missing", entry.URL, entry.SHA256))
+		}
+	}
+
+	objectPaths, err := filepath.Glob(filepath.Join(cache.objectsDirectory(), "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, objectPath := range objectPaths {
+		if strings.HasSuffix(objectPath, ".tmp") {
+			continue
+		}
+
+		objectFile, err := os.Open(objectPath)
+		if err != nil {
+			return nil, &PreparationError{Stage: "verifying cache", File: objectPath, Err: err}
+		}
+		sha256Hash := sha256.New()
+		_, err = io.Copy(sha256Hash, objectFile)
+		objectFile.Close()
+		if err != nil {
+			return nil, &PreparationError{Stage: "verifying cache", File: objectPath, Err: err}
+		}
+
+		actualDigest := hex.EncodeToString(sha256Hash.Sum(nil))
+		if actualDigest != filepath.Base(objectPath) {
+			problem := fmt.Sprintf("cached file %s is corrupt (actual sha256 %s)", filepath.Base(objectPath), actualDigest)
+			if removeCorrupt {
+				err = os.Remove(objectPath)
+				if err != nil {
+					return nil, &PreparationError{Stage: "verifying cache", File: objectPath, Err: err}
+				}
+				problem += ", removed"
+			}
+			problems = append(problems, problem)
+		}
+	}
+
+	return problems, nil
+}
+
+// Prune removes entries whose file is missing or that were not used within maximumAge (when it is positive), then every file no entry refers to, except temporary files younger than abandonedTemporaryObjectAge.
+// With dryRun, it returns what it would remove without removing it.
+func (cache *DownloadCache) Prune(maximumAge time.Duration, dryRun bool) ([]string, error) {
+	removed := make([]string, 0)
+
+	entries, err := cache.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	referencedObjects := make(map[string]bool)
+	for _, entry := range entries {
+		_, err := os.Stat(cache.objectPath(entry.SHA256))
+		isMissing := err != nil
+		isOld := maximumAge > 0 && time.Since(entry.LastUsedAt) > maximumAge
+		if isMissing || isOld {
+			if !dryRun {
+				err = os.Remove(cache.entryPath(entry.URL))
+				if err != nil {
+					return nil, &PreparationError{Stage: "pruning cache", File: cache.entryPath(entry.URL), Err: err}
+				}
+			}
+			removed = append(removed, entry.URL)
+			continue
+		}
+		referencedObjects[entry.SHA256] = true
+	}
+
+	objectPaths, err := filepath.Glob(filepath.Join(cache.objectsDirectory(), "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, objectPath := range objectPaths {
+		if referencedObjects[filepath.Base(objectPath)] {
+			continue
+		}
+		if strings.HasSuffix(objectPath, ".tmp") {
+			objectInfo, err := os.Stat(objectPath)
+			if err != nil || time.Since(objectInfo.ModTime()) < abandonedTemporaryObjectAge {
+				continue
+			}
+		}
+		if !dryRun {
+			err = os.Remove(objectPath)
+			if err != nil {
+				return nil, &PreparationError{Stage: "pruning cache", File: objectPath, Err: err}
+			}
+		}
+		removed = append(removed, filepath.Base(objectPath))
+	}
+
+	return removed, nil
+}
+
+func linkOrCopyFile(sourcePath string, destinationPath string) error {
+	err := os.Link(sourcePath, destinationPath)
+	if err == nil {
+		return nil
+	}
+
+	sourceFile, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destinationFile, err := os.OpenFile(destinationPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FileMode())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(destinationFile, sourceFile)
+	closeErr := destinationFile.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
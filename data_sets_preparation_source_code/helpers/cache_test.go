@@ -0,0 +1,225 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Opens a cache in a temporary directory and adds a file of the content for each URL.
+func testDownloadCache(t *testing.T, contents map[string]string) (*DownloadCache, map[string]ManifestEntry) {
+	directory := t.TempDir()
+	cache, err := OpenDownloadCache(filepath.Join(directory, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifestEntries := make(map[string]ManifestEntry)
+	for url, content := range contents {
+		filePath := filepath.Join(directory, filepath.Base(url))
+		err = os.WriteFile(filePath, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifestEntry, err := newManifestEntry(url, filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = cache.add(manifestEntry, filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifestEntries[url] = manifestEntry
+	}
+	return cache, manifestEntries
+}
+
+func TestDownloadCacheAddAndLinkInto(t *testing.T) {
+	cache, manifestEntries := testDownloadCache(t, map[string]string{
+		"http://host/a.txt":      "content a",
+		"http://host/copy_a.txt": "content a",
+		"http://host/b.txt":      "content b",
+	})
+	manifestEntry := manifestEntries["http://host/a.txt"]
+
+	objectPaths, err := filepath.Glob(filepath.Join(cache.objectsDirectory(), "*"))
+	if err != nil || len(objectPaths) != 2 {
+		t.Fatalf("expected an object per distinct content, got %v (%v)", objectPaths, err)
+	}
+
+	entry := cache.entryOf("http://host/a.txt")
+	if entry == nil || entry.Size != manifestEntry.Size || entry.SHA256 != manifestEntry.SHA256 || entry.MD5 != manifestEntry.MD5 {
+		t.Fatalf("entryOf = %+v, expected the size and digests of %+v", entry, manifestEntry)
+	}
+	if cache.entryOf("http://host/missing.txt") != nil || (*DownloadCache)(nil).entryOf("http://host/a.txt") != nil {
+		t.Fatal("expected no entry of a URL that is not cached or of a nil cache")
+	}
+
+	tests := []struct {
+		name          string
+		url           string
+		checksum      string
+		expectCopy    bool
+		expectContent string
+	}{
+		{name: "cached", url: "http://host/a.txt", expectCopy: true, expectContent: "content a"},
+		{name: "matching sha256", url: "http://host/a.txt", checksum: "sha256:" + manifestEntry.SHA256, expectCopy: true, expectContent: "content a"},
+		{name: "matching md5", url: "http://host/a.txt", checksum: manifestEntry.MD5, expectCopy: true, expectContent: "content a"},
+		{name: "mismatching checksum", url: "http://host/a.txt", checksum: "sha256:" + manifestEntries["http://host/b.txt"].SHA256},
+		{name: "not cached", url: "http://host/missing.txt"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "downloaded.txt")
+			err := os.WriteFile(filePath, []byte("partial"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			linkedEntry, err := cache.linkInto(test.url, test.checksum, filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.expectCopy {
+				if linkedEntry != nil {
+					t.Fatalf("expected a cache miss, got %+v", linkedEntry)
+				}
+				return
+			}
+			if linkedEntry == nil || linkedEntry.SHA256 != manifestEntry.SHA256 {
+				t.Fatalf("linkInto = %+v, expected the entry of %s", linkedEntry, test.url)
+			}
+			content, err := os.ReadFile(filePath)
+			if err != nil || string(content) != test.expectContent {
+				t.Fatalf("file content = %q (%v), expected %q", content, err, test.expectContent)
+			}
+		})
+	}
+
+	err = os.Truncate(cache.objectPath(manifestEntries["http://host/b.txt"].SHA256), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.entryOf("http://host/b.txt") != nil {
+		t.Fatal("expected no entry of an incomplete cached file")
+	}
+	linkedEntry, err := cache.linkInto("http://host/b.txt", "", filepath.Join(t.TempDir(), "b.txt"))
+	if err != nil || linkedEntry != nil {
+		t.Fatalf("expected a cache miss for an incomplete cached file, got %+v (%v)", linkedEntry, err)
+	}
+}
+
+func TestDownloadCacheVerify(t *testing.T) {
+	cache, manifestEntries := testDownloadCache(t, map[string]string{
+		"http://host/a.txt": "content a",
+		"http://host/b.txt": "content b",
+		"http://host/c.txt": "content c",
+	})
+	corruptObjectPath := cache.objectPath(manifestEntries["http://host/a.txt"].SHA256)
+	err := os.Remove(corruptObjectPath)
+	if err == nil {
+		err = os.WriteFile(corruptObjectPath, []byte("corrupt a"), 0644)
+	}
+	if err == nil {
+		err = os.Remove(cache.objectPath(manifestEntries["http://host/b.txt"].SHA256))
+	}
+	if err == nil {
+		err = os.WriteFile(cache.objectPath(manifestEntries["http://host/c.txt"].SHA256)+".1.tmp", []byte("being added"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, removeCorrupt := range []bool{false, true} {
+		problems, err := cache.Verify(removeCorrupt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 2 || !strings.Contains(problems[0], "http://host/b.txt") || !strings.Contains(problems[1], "is corrupt") {
+			t.Fatalf("Verify(%v) = %q, expected the missing b.txt and the corrupt a.txt", removeCorrupt, problems)
+		}
+		_, err = os.Stat(corruptObjectPath)
+		if removeCorrupt != os.IsNotExist(err) {
+			t.Fatalf("Verify(%v) left the corrupt file: %v", removeCorrupt, err == nil)
+		}
+	}
+}
+
+func TestDownloadCachePrune(t *testing.T) {
+	cache, manifestEntries := testDownloadCache(t, map[string]string{
+		"http://host/used.txt":    "used",
+		"http://host/old.txt":     "old",
+		"http://host/missing.txt": "missing",
+	})
+
+	oldEntry := cache.entryOf("http://host/old.txt")
+	oldEntry.LastUsedAt = time.Now().Add(-2 * time.Hour)
+	err := cache.writeEntry(oldEntry)
+	if err == nil {
+		err = os.Remove(cache.objectPath(manifestEntries["http://host/missing.txt"].SHA256))
+	}
+	unreferencedObjectPath := cache.objectPath(strings.Repeat("0", 64))
+	if err == nil {
+		err = os.WriteFile(unreferencedObjectPath, []byte("unreferenced"), 0644)
+	}
+	youngTemporaryObjectPath := unreferencedObjectPath + ".1.tmp"
+	abandonedTemporaryObjectPath := unreferencedObjectPath + ".2.tmp"
+	for _, temporaryObjectPath := range []string{youngTemporaryObjectPath, abandonedTemporaryObjectPath} {
+		if err == nil {
+			err = os.WriteFile(temporaryObjectPath, []byte("being added"), 0644)
+		}
+	}
+	if err == nil {
+		abandonedTime := time.Now().Add(-abandonedTemporaryObjectAge - time.Hour)
+		err = os.Chtimes(abandonedTemporaryObjectPath, abandonedTime, abandonedTime)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRemoved := []string{
+		filepath.Base(unreferencedObjectPath),
+		filepath.Base(abandonedTemporaryObjectPath),
+		manifestEntries["http://host/old.txt"].SHA256,
+		"http://host/missing.txt",
+		"http://host/old.txt",
+	}
+	sort.Strings(expectedRemoved)
+
+	for _, dryRun := range []bool{true, false} {
+		removed, err := cache.Prune(time.Hour, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(removed)
+		if !reflect.DeepEqual(removed, expectedRemoved) {
+			t.Fatalf("Prune(dry run %v) removed %q, expected %q", dryRun, removed, expectedRemoved)
+		}
+
+		_, err = os.Stat(abandonedTemporaryObjectPath)
+		if dryRun != (err == nil) || dryRun != (cache.entryOf("http://host/old.txt") != nil) {
+			t.Fatalf("Prune(dry run %v) left the abandoned file (%v) and the old entry (%v)", dryRun, err == nil, cache.entryOf("http://host/old.txt") != nil)
+		}
+	}
+
+	if cache.entryOf("http://host/used.txt") == nil {
+		t.Fatal("Prune removed the entry in use")
+	}
+	_, err = os.Stat(youngTemporaryObjectPath)
+	if err != nil {
+		t.Fatalf("Prune removed a temporary file that may be being added: %v", err)
+	}
+}
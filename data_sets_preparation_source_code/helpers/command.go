@@ -0,0 +1,69 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// Runs an external tool, printing its output at the debug level and writing it to the run log. Cancelling the context kills the tool.
+func RunCommand(ctx context.Context, cmd *exec.Cmd, tool string) error {
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	startInProcessGroup(cmd)
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-finished:
+		}
+	}()
+
+	outputWaitGroup := sync.WaitGroup{}
+	outputWaitGroup.Add(2)
+	go logCommandOutput(&outputWaitGroup, stdoutPipe, tool, "stdout")
+	go logCommandOutput(&outputWaitGroup, stderrPipe, tool, "stderr")
+
+	// Wait closes the pipes, so the output is read to the end first.
+	outputWaitGroup.Wait()
+	err = cmd.Wait()
+	close(finished)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func logCommandOutput(waitGroup *sync.WaitGroup, pipe io.Reader, tool string, stream string) {
+	defer waitGroup.Done()
+	scanner := bufio.NewScanner(pipe)
+	for scanner.Scan() {
+		Log.Debug("external_tool_output", LogFields{"tool": tool, "stream": stream}, scanner.Text())
+	}
+}
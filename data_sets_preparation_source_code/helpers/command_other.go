@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !freebsd
+
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"os/exec"
+)
+
+func startInProcessGroup(cmd *exec.Cmd) {
+}
+
+// Only the tool itself is killed, not the processes it started.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
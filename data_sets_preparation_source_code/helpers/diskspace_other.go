@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"errors"
+)
+
+func availableDiskSpace(directory string) (int64, error) {
+	return -1, errors.New("available disk space is not known on this operating system")
+}
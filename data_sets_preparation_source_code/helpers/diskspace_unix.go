@@ -0,0 +1,24 @@
+//go:build linux || darwin || freebsd
+
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"syscall"
+)
+
+func availableDiskSpace(directory string) (int64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(directory, &stat)
+	if err != nil {
+		return -1, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
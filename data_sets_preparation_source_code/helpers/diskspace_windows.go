@@ -0,0 +1,32 @@
+//go:build windows
+
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+func availableDiskSpace(directory string) (int64, error) {
+	directoryPointer, err := syscall.UTF16PtrFromString(directory)
+	if err != nil {
+		return -1, err
+	}
+
+	var freeBytesAvailable uint64
+	result, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(directoryPointer)), uintptr(unsafe.Pointer(&freeBytesAvailable)), 0, 0)
+	if result == 0 {
+		return -1, err
+	}
+	return int64(freeBytesAvailable), nil
+}
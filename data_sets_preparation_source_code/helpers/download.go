@@ -0,0 +1,333 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type downloader interface {
+	attempt(ctx context.Context, url string, partFilePath string, progress *downloadProgress) (fileSize int64, retryable bool, err error)
+	// Returns -1 if the server does not tell the size.
+	size(ctx context.Context, url string) (int64, error)
+}
+
+type retryPolicy struct {
+	maximumAttempts int
+	initialBackoff  time.Duration
+	maximumBackoff  time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	maximumAttempts: 8,
+	initialBackoff:  2 * time.Second,
+	maximumBackoff:  2 * time.Minute,
+}
+
+var downloaders = map[string]downloader{
+	"http":  newHTTPDownloader(),
+	"https": newHTTPDownloader(),
+	"ftp":   newFTPDownloader(),
+}
+
+func downloaderOf(url string) (downloader, error) {
+	scheme := ""
+	if schemeEndIndex := strings.Index(url, "://"); schemeEndIndex > 0 {
+		scheme = strings.ToLower(url[:schemeEndIndex])
+	}
+
+	selectedDownloader, ok := downloaders[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported URL scheme %q", scheme)
+	}
+	return selectedDownloader, nil
+}
+
+func downloadFile(ctx context.Context, url string, partFilePath string, filePath string, progress *downloadProgress) error {
+	selectedDownloader, err := downloaderOf(url)
+	if err != nil {
+		return &PreparationError{Stage: StageDownload, URL: url, File: filePath, Err: err}
+	}
+
+	return downloadWithRetries(ctx, selectedDownloader, defaultRetryPolicy, url, partFilePath, filePath, progress)
+}
+
+func remoteFileSize(ctx context.Context, url string) (int64, error) {
+	selectedDownloader, err := downloaderOf(url)
+	if err != nil {
+		return -1, err
+	}
+	return selectedDownloader.size(ctx, url)
+}
+
+// The partial file is kept between attempts and runs, so that retries and resumed runs continue it.
+func downloadWithRetries(ctx context.Context, selectedDownloader downloader, policy retryPolicy, url string, partFilePath string, filePath string, progress *downloadProgress) error {
+	err := os.MkdirAll(filepath.Dir(partFilePath), DirectoryMode())
+	if err != nil {
+		return &PreparationError{Stage: StageDownload, URL: url, File: filepath.Dir(partFilePath), Err: err}
+	}
+
+	backoff := policy.initialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= policy.maximumAttempts; attempt++ {
+		if attempt > 1 {
+			Log.Warning("download_retry", LogFields{"url": url, "attempt": attempt, "maximum_attempts": policy.maximumAttempts, "backoff": backoff.String(), "error": lastErr.Error()}, "| Retrying", url, "in", backoff, "( attempt", attempt, "/", policy.maximumAttempts, ") after error:", lastErr)
+			err = sleepContext(ctx, backoff)
+			if err != nil {
+				return &PreparationError{Stage: StageDownload, URL: url, File: partFilePath, Err: err}
+			}
+			backoff *= 2
+			if backoff > policy.maximumBackoff {
+				backoff = policy.maximumBackoff
+			}
+		}
+
+		fileSize, retryable, err := selectedDownloader.attempt(ctx, url, partFilePath, progress)
+		if ctx.Err() != nil {
+			return &PreparationError{Stage: StageDownload, URL: url, File: partFilePath, Err: ctx.Err()}
+		}
+		if err == nil {
+			err = os.Rename(partFilePath, filePath)
+			if err != nil {
+				return &PreparationError{Stage: StageDownload, URL: url, File: filePath, Err: err}
+			}
+
+			progress.finishFile(url, fileSize)
+			Log.Info("downloaded", LogFields{"url": url, "file": filePath, "size": fileSize}, "| Downloaded", url, "| Size~= ", fileSize/1024, "KB")
+			return nil
+		}
+
+		if !retryable {
+			return &PreparationError{Stage: StageDownload, URL: url, File: partFilePath, Err: err}
+		}
+		lastErr = err
+	}
+
+	return &PreparationError{Stage: StageDownload, URL: url, File: partFilePath, Err: fmt.Errorf("giving up after %d attempts: %w", policy.maximumAttempts, lastErr)}
+}
+
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Closes the connection when the context is cancelled, so that blocked reads and writes return.
+func closeOnCancel(ctx context.Context, closer io.Closer) (stop func()) {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			closer.Close()
+		case <-stopped:
+		}
+	}()
+	return func() {
+		close(stopped)
+	}
+}
+
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (reader contextReader) Read(buffer []byte) (int, error) {
+	if reader.ctx.Err() != nil {
+		return 0, reader.ctx.Err()
+	}
+	return reader.reader.Read(buffer)
+}
+
+func partFileSize(partFilePath string) (int64, error) {
+	partFileInfo, err := os.Stat(partFilePath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return partFileInfo.Size(), nil
+}
+
+// A totalSize of -1 means the size is not known in advance and any size is accepted.
+func writePartFile(partFilePath string, offset int64, totalSize int64, body io.Reader, progress *downloadProgress, url string) (fileSize int64, retryable bool, err error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if offset == 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+	file, err := os.OpenFile(partFilePath, flags, FileMode())
+	if err != nil {
+		return 0, false, err
+	}
+
+	partFile := &partFileWriter{file: file}
+	var writer io.Writer = partFile
+	if progress != nil {
+		progress.startFile(url, offset, totalSize)
+		writer = io.MultiWriter(partFile, progressWriter{progress: progress, url: url})
+	}
+	copiedSize, copyErr := io.Copy(writer, body)
+	closeErr := file.Close()
+	fileSize = offset + copiedSize
+	if partFile.err != nil {
+		// Local failures such as a full disk are not fixed by downloading again.
+		return fileSize, false, partFile.err
+	}
+	if copyErr != nil {
+		return fileSize, true, copyErr
+	}
+	if closeErr != nil {
+		return fileSize, false, closeErr
+	}
+
+	if totalSize >= 0 && fileSize < totalSize {
+		return fileSize, true, fmt.Errorf("connection closed after %d of %d bytes", fileSize, totalSize)
+	} else if totalSize >= 0 && fileSize > totalSize {
+		// The server reports a wrong size, which downloading again does not change.
+		return 0, false, discardPartFile(partFilePath, fmt.Errorf("downloaded %d bytes but expected %d bytes", fileSize, totalSize))
+	}
+
+	return fileSize, false, nil
+}
+
+// Tells write errors apart from errors reading the body.
+type partFileWriter struct {
+	file *os.File
+	err  error
+}
+
+func (writer *partFileWriter) Write(buffer []byte) (int, error) {
+	n, err := writer.file.Write(buffer)
+	if err != nil {
+		writer.err = err
+	}
+	return n, err
+}
+
+func discardPartFile(partFilePath string, reason error) error {
+	err := os.Remove(partFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return reason
+}
+
+const (
+	LocalFilesHardLink     = "hardlink"
+	LocalFilesSymbolicLink = "symlink"
+	LocalFilesCopy         = "copy"
+)
+
+// Hard links fall back to copying, since they are not possible across file systems.
+func copyLocalFile(ctx context.Context, localPath string, partFilePath string, filePath string, localFiles string) error {
+	Log.Info("using_local_file", LogFields{"local_path": localPath, "file": filePath, "local_files": localFiles}, "Using local file...", localPath)
+
+	localFileInfo, err := os.Stat(localPath)
+	if err != nil {
+		return &PreparationError{Stage: StageDownload, URL: localPath, File: filePath, Err: err}
+	}
+	if localFileInfo.IsDir() {
+		return &PreparationError{Stage: StageDownload, URL: localPath, File: filePath, Err: errors.New("local input is a directory")}
+	}
+
+	err = os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return &PreparationError{Stage: StageDownload, URL: localPath, File: filePath, Err: err}
+	}
+
+	if localFiles == LocalFilesSymbolicLink {
+		absoluteLocalPath, err := filepath.Abs(localPath)
+		if err != nil {
+			return &PreparationError{Stage: StageDownload, URL: localPath, File: filePath, Err: err}
+		}
+		err = os.Symlink(absoluteLocalPath, filePath)
+		if err != nil {
+			return &PreparationError{Stage: StageDownload, URL: localPath, File: filePath, Err: err}
+		}
+		Log.Info("local_file_linked", LogFields{"local_path": localPath, "file": filePath, "link": LocalFilesSymbolicLink}, "| Linked (symbolic)", localPath)
+		Log.EmptyLine()
+		return nil
+	}
+
+	if localFiles == "" || localFiles == LocalFilesHardLink {
+		err = os.Link(localPath, filePath)
+		if err == nil {
+			Log.Info("local_file_linked", LogFields{"local_path": localPath, "file": filePath, "link": LocalFilesHardLink}, "| Linked (hard)", localPath)
+			Log.EmptyLine()
+			return nil
+		}
+		Log.Warning("hard_link_failed", LogFields{"local_path": localPath, "error": err.Error()}, "| Hard link not possible, copying instead:", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(partFilePath), DirectoryMode())
+	if err != nil {
+		return &PreparationError{Stage: StageDownload, URL: localPath, File: filepath.Dir(partFilePath), Err: err}
+	}
+
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return &PreparationError{Stage: StageDownload, URL: localPath, File: filePath, Err: err}
+	}
+	defer localFile.Close()
+
+	partFile, err := os.OpenFile(partFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FileMode())
+	if err != nil {
+		return &PreparationError{Stage: StageDownload, URL: localPath, File: partFilePath, Err: err}
+	}
+	fileSize, err := io.Copy(partFile, contextReader{ctx: ctx, reader: localFile})
+	closeErr := partFile.Close()
+	if err != nil {
+		return &PreparationError{Stage: StageDownload, URL: localPath, File: partFilePath, Err: err}
+	}
+	if closeErr != nil {
+		return &PreparationError{Stage: StageDownload, URL: localPath, File: partFilePath, Err: closeErr}
+	}
+	if fileSize != localFileInfo.Size() {
+		return &PreparationError{Stage: StageDownload, URL: localPath, File: partFilePath, Err: fmt.Errorf("copied %d bytes but expected %d bytes", fileSize, localFileInfo.Size())}
+	}
+
+	err = os.Rename(partFilePath, filePath)
+	if err != nil {
+		return &PreparationError{Stage: StageDownload, URL: localPath, File: filePath, Err: err}
+	}
+
+	Log.Info("local_file_copied", LogFields{"local_path": localPath, "file": filePath, "size": fileSize}, "| Copied", localPath, "| Size~= ", fileSize/1024, "KB")
+	Log.EmptyLine()
+	return nil
+}
+
+func localPathFromFileURL(fileURL string) string {
+	parsedURL, err := url.Parse(fileURL)
+	if err != nil {
+		return filepath.FromSlash(strings.TrimPrefix(fileURL, "file://"))
+	}
+
+	localPath := parsedURL.Path
+	if parsedURL.Host != "" && parsedURL.Host != "localhost" {
+		localPath = "//" + parsedURL.Host + localPath
+	}
+	if len(localPath) >= 3 && localPath[0] == '/' && localPath[2] == ':' {
+		localPath = localPath[1:]
+	}
+
+	return filepath.FromSlash(localPath)
+}
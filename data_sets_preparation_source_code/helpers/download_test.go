@@ -0,0 +1,262 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = retryPolicy{maximumAttempts: 8, initialBackoff: time.Millisecond, maximumBackoff: time.Millisecond}
+
+// Serves content, closing the connection after dropAfter bytes of the body of the first numberOfDrops responses.
+type droppingServer struct {
+	content       []byte
+	dropAfter     int
+	numberOfDrops int
+	ignoreRange   bool
+
+	// Sent as the ETag, or as the Last-Modified date if lastModified is set, and compared with If-Range.
+	validator    string
+	lastModified bool
+
+	// Served after the first response, with another ETag.
+	changedContent []byte
+
+	mutex    sync.Mutex
+	ranges   []string
+	ifRanges []string
+}
+
+func (server *droppingServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	server.mutex.Lock()
+	requestNumber := len(server.ranges)
+	server.ranges = append(server.ranges, request.Header.Get("Range"))
+	server.ifRanges = append(server.ifRanges, request.Header.Get("If-Range"))
+	server.mutex.Unlock()
+
+	content, validator := server.content, server.validator
+	if requestNumber > 0 && server.changedContent != nil {
+		content, validator = server.changedContent, server.validator+"-changed"
+	}
+
+	start := 0
+	header := "HTTP/1.1 200 OK\r\n"
+	ifRange := request.Header.Get("If-Range")
+	if request.Header.Get("Range") != "" && !server.ignoreRange && (ifRange == "" || ifRange == validator) {
+		fmt.Sscanf(request.Header.Get("Range"), "bytes=%d-", &start)
+		header = fmt.Sprintf("HTTP/1.1 206 Partial Content\r\nContent-Range: bytes %d-%d/%d\r\n", start, len(content)-1, len(content))
+	}
+	if validator != "" && server.lastModified {
+		header += "Last-Modified: " + validator + "\r\n"
+	} else if validator != "" {
+		header += "ETag: " + validator + "\r\n"
+	}
+	body := content[start:]
+	header += fmt.Sprintf("Content-Length: %d\r\nConnection: close\r\n\r\n", len(body))
+
+	sentBody := body
+	if requestNumber < server.numberOfDrops && server.dropAfter < len(body) {
+		sentBody = body[:server.dropAfter]
+	}
+
+	connection, buffer, err := writer.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer connection.Close()
+	buffer.WriteString(header)
+	buffer.Write(sentBody)
+	buffer.Flush()
+}
+
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(content)
+	return content
+}
+
+func TestDownloadResumesDroppedConnections(t *testing.T) {
+	content := testContent(10000)
+	changedContent := testContent(12000)[2000:]
+	lastModified := "Wed, 21 Oct 2015 07:28:00 GMT"
+	tests := []struct {
+		name             string
+		server           *droppingServer
+		expectedContent  []byte
+		expectedRanges   []string
+		expectedIfRanges []string
+	}{
+		{
+			name:             "resumed with range requests",
+			server:           &droppingServer{content: content, dropAfter: 3000, numberOfDrops: 100, validator: `"v1"`},
+			expectedContent:  content,
+			expectedRanges:   []string{"", "bytes=3000-", "bytes=6000-", "bytes=9000-"},
+			expectedIfRanges: []string{"", `"v1"`, `"v1"`, `"v1"`},
+		},
+		{
+			name:             "resumed with the last modified date",
+			server:           &droppingServer{content: content, dropAfter: 6000, numberOfDrops: 1, validator: lastModified, lastModified: true},
+			expectedContent:  content,
+			expectedRanges:   []string{"", "bytes=6000-"},
+			expectedIfRanges: []string{"", lastModified},
+		},
+		{
+			name:             "weak ETags are not used",
+			server:           &droppingServer{content: content, dropAfter: 3000, numberOfDrops: 1, validator: `W/"v1"`},
+			expectedContent:  content,
+			expectedRanges:   []string{"", ""},
+			expectedIfRanges: []string{"", ""},
+		},
+		{
+			name:             "restarted when the server ignores the range",
+			server:           &droppingServer{content: content, dropAfter: 3000, numberOfDrops: 1, ignoreRange: true, validator: `"v1"`},
+			expectedContent:  content,
+			expectedRanges:   []string{"", "bytes=3000-"},
+			expectedIfRanges: []string{"", `"v1"`},
+		},
+		{
+			name:             "restarted when the file changed",
+			server:           &droppingServer{content: content, dropAfter: 3000, numberOfDrops: 1, validator: `"v1"`, changedContent: changedContent},
+			expectedContent:  changedContent,
+			expectedRanges:   []string{"", "bytes=3000-"},
+			expectedIfRanges: []string{"", `"v1"`},
+		},
+		{
+			name:             "restarted without an ETag or last modified date",
+			server:           &droppingServer{content: content, dropAfter: 3000, numberOfDrops: 1},
+			expectedContent:  content,
+			expectedRanges:   []string{"", ""},
+			expectedIfRanges: []string{"", ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.server)
+			defer server.Close()
+
+			directory := t.TempDir()
+			partFilePath := filepath.Join(directory, "Partial_downloads", "file.part")
+			filePath := filepath.Join(directory, "file")
+			err := downloadWithRetries(context.Background(), newHTTPDownloader(), testRetryPolicy, server.URL+"/file", partFilePath, filePath, newDownloadProgress())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			downloaded, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(downloaded, test.expectedContent) {
+				t.Errorf("downloaded %d bytes that differ from the %d bytes expected", len(downloaded), len(test.expectedContent))
+			}
+			for _, leftFilePath := range []string{partFilePath, partFileValidatorPath(partFilePath)} {
+				if _, err := os.Stat(leftFilePath); !os.IsNotExist(err) {
+					t.Errorf("%s is left after the download: %v", leftFilePath, err)
+				}
+			}
+			if !reflect.DeepEqual(test.server.ranges, test.expectedRanges) {
+				t.Errorf("range headers %q, expected %q", test.server.ranges, test.expectedRanges)
+			}
+			if !reflect.DeepEqual(test.server.ifRanges, test.expectedIfRanges) {
+				t.Errorf("If-Range headers %q, expected %q", test.server.ifRanges, test.expectedIfRanges)
+			}
+		})
+	}
+}
+
+func TestDownloadGivesUpAfterMaximumAttempts(t *testing.T) {
+	handler := &droppingServer{content: testContent(10000), dropAfter: 0, numberOfDrops: 100, ignoreRange: true}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	directory := t.TempDir()
+	err := downloadWithRetries(context.Background(), newHTTPDownloader(), testRetryPolicy, server.URL+"/file", filepath.Join(directory, "file.part"), filepath.Join(directory, "file"), nil)
+	if err == nil || !strings.Contains(err.Error(), "giving up after 8 attempts") {
+		t.Fatalf("expected giving up after 8 attempts, got %v", err)
+	}
+	if len(handler.ranges) != testRetryPolicy.maximumAttempts {
+		t.Errorf("%d requests, expected %d", len(handler.ranges), testRetryPolicy.maximumAttempts)
+	}
+}
+
+func TestPartFileWriteFailuresAreNotRetried(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+
+	_, retryable, err := writePartFile("/dev/full", 0, -1, bytes.NewReader(testContent(1000)), nil, "http://localhost/file")
+	if err == nil {
+		t.Fatal("writing to /dev/full succeeded")
+	}
+	if retryable {
+		t.Errorf("write failure %v is retryable", err)
+	}
+}
+
+func TestFirstDownloadErrorCancelsTheOtherDownloads(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !strings.HasSuffix(request.URL.Path, "/slow.bin") {
+			http.NotFound(writer, request)
+			return
+		}
+		writer.Header().Set("Content-Length", "1000000")
+		if request.Method == http.MethodHead {
+			return
+		}
+		writer.Write(testContent(1000))
+		writer.(http.Flusher).Flush()
+		select {
+		case <-request.Context().Done():
+		case <-time.After(time.Minute):
+		}
+	}))
+	defer server.Close()
+
+	startedAt := time.Now()
+	dataSetPreparationInformation := &DataSetPreparationInformation{
+		Name:                      "cancellation_test",
+		PrefixOfInputDownloadURLs: server.URL + "/",
+		InputDownloadURLs:         []string{"slow.bin", "missing.bin"},
+		Options:                   PreparationOptions{MaximumConcurrentDownloads: 2, ResolvedConfig: []byte("{}\n")},
+	}
+	err := dataSetPreparationInformation.Prepare(context.Background(), filepath.Join(t.TempDir(), "output"))
+	var preparationError *PreparationError
+	if !errors.As(err, &preparationError) || !strings.HasSuffix(preparationError.URL, "/missing.bin") {
+		t.Fatalf("expected the error of missing.bin, got %v", err)
+	}
+	if time.Since(startedAt) > 30*time.Second {
+		t.Fatalf("the slow download went on for %v after the error", time.Since(startedAt))
+	}
+}
+
+func TestPartFileLargerThanTheSizeIsNotRetried(t *testing.T) {
+	partFilePath := filepath.Join(t.TempDir(), "file.part")
+	_, retryable, err := writePartFile(partFilePath, 0, 900, bytes.NewReader(testContent(1000)), nil, "http://localhost/file")
+	if err == nil || retryable {
+		t.Fatalf("expected an error that is not retryable, got %v (retryable %v)", err, retryable)
+	}
+	if _, err := os.Stat(partFilePath); !os.IsNotExist(err) {
+		t.Errorf("partial file is left: %v", err)
+	}
+}
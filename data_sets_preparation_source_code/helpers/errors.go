@@ -0,0 +1,52 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"strings"
+)
+
+const (
+	StageSetup       = "setup"
+	StageDownload    = "download"
+	StageUncompress  = "uncompress"
+	StageProcessing  = "processing"
+	StageArguments   = "arguments"
+	StageWritingFile = "writing file"
+)
+
+type PreparationError struct {
+	Stage string
+	URL   string
+	File  string
+	Err   error
+}
+
+func (preparationError *PreparationError) Error() string {
+	message := strings.Builder{}
+	message.WriteString("Stage: ")
+	message.WriteString(preparationError.Stage)
+	if preparationError.URL != "" {
+		message.WriteString(" | URL: ")
+		message.WriteString(preparationError.URL)
+	}
+	if preparationError.File != "" {
+		message.WriteString(" | File: ")
+		message.WriteString(preparationError.File)
+	}
+	if preparationError.Err != nil {
+		message.WriteString(" | ")
+		message.WriteString(preparationError.Err.Error())
+	}
+	return message.String()
+}
+
+func (preparationError *PreparationError) Unwrap() error {
+	return preparationError.Err
+}
@@ -0,0 +1,413 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	UnsafeArchiveEntriesReject = "reject"
+	UnsafeArchiveEntriesSkip   = "skip"
+)
+
+const (
+	DefaultMaximumUncompressedSize int64 = 64 << 30
+	DefaultMaximumArchiveEntries         = 2000000
+)
+
+// Entries escaping the uncompressed directory, and special files.
+type unsafeArchiveEntryError struct {
+	name   string
+	reason string
+}
+
+func (err *unsafeArchiveEntryError) Error() string {
+	return fmt.Sprintf("entry %q %s", err.name, err.reason)
+}
+
+// Stops the extraction even with UnsafeArchiveEntriesSkip.
+type archiveLimitError struct {
+	limit string
+	value int64
+}
+
+func (err *archiveLimitError) Error() string {
+	return fmt.Sprintf("archive exceeds the maximum %s of %d", err.limit, err.value)
+}
+
+type extraction struct {
+	ctx                           context.Context
+	compressedFile                string
+	uncompressedDirectory         string
+	resolvedUncompressedDirectory string
+	unsafeEntries                 string
+	maximumUncompressedSize       int64
+	maximumEntries                int
+	uncompressedSize              int64
+	entries                       int
+}
+
+func newExtraction(ctx context.Context, compressedFile string, uncompressedDirectory string, options PreparationOptions) (*extraction, error) {
+	err := os.MkdirAll(uncompressedDirectory, DirectoryMode())
+	if err != nil {
+		return nil, &PreparationError{Stage: StageUncompress, File: uncompressedDirectory, Err: err}
+	}
+	resolvedUncompressedDirectory, err := filepath.EvalSymlinks(uncompressedDirectory)
+	if err != nil {
+		return nil, &PreparationError{Stage: StageUncompress, File: uncompressedDirectory, Err: err}
+	}
+
+	extraction := &extraction{
+		ctx:                           ctx,
+		compressedFile:                compressedFile,
+		uncompressedDirectory:         filepath.Clean(uncompressedDirectory),
+		resolvedUncompressedDirectory: resolvedUncompressedDirectory,
+		unsafeEntries:                 options.UnsafeArchiveEntries,
+		maximumUncompressedSize:       options.MaximumUncompressedSize,
+		maximumEntries:                options.MaximumArchiveEntries,
+	}
+	if extraction.maximumUncompressedSize == 0 {
+		extraction.maximumUncompressedSize = DefaultMaximumUncompressedSize
+	}
+	if extraction.maximumEntries == 0 {
+		extraction.maximumEntries = DefaultMaximumArchiveEntries
+	}
+	return extraction, nil
+}
+
+func (extraction *extraction) entryError(err error) error {
+	var unsafeEntryError *unsafeArchiveEntryError
+	var limitError *archiveLimitError
+	if errors.As(err, &unsafeEntryError) && extraction.unsafeEntries == UnsafeArchiveEntriesSkip {
+		Log.Warning("unsafe_archive_entry_skipped", LogFields{"archive": extraction.compressedFile, "error": unsafeEntryError.Error()}, "| Skipped unsafe archive entry:", unsafeEntryError.Error())
+		return nil
+	} else if unsafeEntryError != nil || errors.As(err, &limitError) {
+		return &PreparationError{Stage: StageUncompress, File: extraction.compressedFile, Err: err}
+	}
+	return err
+}
+
+func (extraction *extraction) countEntry() error {
+	if extraction.ctx.Err() != nil {
+		return &PreparationError{Stage: StageUncompress, File: extraction.compressedFile, Err: extraction.ctx.Err()}
+	}
+	extraction.entries++
+	if extraction.entries > extraction.maximumEntries {
+		return &archiveLimitError{limit: "number of entries", value: int64(extraction.maximumEntries)}
+	}
+	return nil
+}
+
+func isInsideDirectory(path string, directory string) bool {
+	relativePath, err := filepath.Rel(directory, path)
+	if err != nil {
+		return false
+	}
+	return relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) && !filepath.IsAbs(relativePath)
+}
+
+// The parent directory is resolved through the links already extracted, so that no entry is written through a link to outside.
+func (extraction *extraction) entryPath(name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", &unsafeArchiveEntryError{name: name, reason: "has an absolute path"}
+	}
+	entryPath := filepath.Join(extraction.uncompressedDirectory, name)
+	if !isInsideDirectory(entryPath, extraction.uncompressedDirectory) {
+		return "", &unsafeArchiveEntryError{name: name, reason: "is outside of the uncompressed directory"}
+	}
+	if entryPath == extraction.uncompressedDirectory {
+		return extraction.resolvedUncompressedDirectory, nil
+	}
+
+	parentDirectory := filepath.Dir(entryPath)
+	err := os.MkdirAll(parentDirectory, DirectoryMode())
+	if err != nil {
+		return "", &PreparationError{Stage: StageUncompress, File: parentDirectory, Err: err}
+	}
+	resolvedParentDirectory, err := filepath.EvalSymlinks(parentDirectory)
+	if err != nil {
+		return "", &PreparationError{Stage: StageUncompress, File: parentDirectory, Err: err}
+	}
+	if !isInsideDirectory(resolvedParentDirectory, extraction.resolvedUncompressedDirectory) {
+		return "", &unsafeArchiveEntryError{name: name, reason: "is inside a symbolic link to outside of the uncompressed directory"}
+	}
+
+	// An earlier entry at the same path (a symbolic link in particular) is replaced rather than written through.
+	entryPath = filepath.Join(resolvedParentDirectory, filepath.Base(entryPath))
+	fileInfo, err := os.Lstat(entryPath)
+	if err == nil && !fileInfo.IsDir() {
+		err = os.Remove(entryPath)
+		if err != nil {
+			return "", &PreparationError{Stage: StageUncompress, File: entryPath, Err: err}
+		}
+	}
+
+	return entryPath, nil
+}
+
+// Only the executable bits are kept, so that no entry is setuid or world writable.
+func uncompressedFileModeOf(mode os.FileMode) os.FileMode {
+	if mode&0111 != 0 {
+		return ExecutableFileMode()
+	}
+	return FileMode()
+}
+
+func (extraction *extraction) createDirectory(directory string) error {
+	err := os.MkdirAll(directory, DirectoryMode())
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: directory, Err: err}
+	}
+	return nil
+}
+
+// The uncompressed bytes are counted as well, as the declared size can be wrong.
+func (extraction *extraction) writeFile(filePath string, reader io.Reader, declaredSize int64, mode os.FileMode) error {
+	remainingSize := extraction.maximumUncompressedSize - extraction.uncompressedSize
+	if declaredSize > remainingSize {
+		return &archiveLimitError{limit: "uncompressed size", value: extraction.maximumUncompressedSize}
+	}
+
+	uncompressedFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, uncompressedFileModeOf(mode))
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: filePath, Err: err}
+	}
+	fileSize, err := io.Copy(uncompressedFile, io.LimitReader(contextReader{ctx: extraction.ctx, reader: reader}, remainingSize+1))
+	closeErr := uncompressedFile.Close()
+	extraction.uncompressedSize += fileSize
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: filePath, Err: err}
+	}
+	if closeErr != nil {
+		return &PreparationError{Stage: StageUncompress, File: filePath, Err: closeErr}
+	}
+	if extraction.uncompressedSize > extraction.maximumUncompressedSize {
+		return &archiveLimitError{limit: "uncompressed size", value: extraction.maximumUncompressedSize}
+	}
+	if declaredSize >= 0 && fileSize != declaredSize {
+		return &PreparationError{Stage: StageUncompress, File: filePath, Err: fmt.Errorf("uncompressed %d bytes but expected %d bytes", fileSize, declaredSize)}
+	}
+	return nil
+}
+
+// Targets are relative to the directory of the link and must stay inside.
+func (extraction *extraction) symbolicLink(name string, linkPath string, target string) error {
+	if target == "" || filepath.IsAbs(target) || strings.HasPrefix(target, "/") {
+		return &unsafeArchiveEntryError{name: name, reason: fmt.Sprintf("is a symbolic link to the absolute path %q", target)}
+	}
+	targetPath := filepath.Join(filepath.Dir(linkPath), target)
+	if !isInsideDirectory(targetPath, extraction.resolvedUncompressedDirectory) {
+		return &unsafeArchiveEntryError{name: name, reason: fmt.Sprintf("is a symbolic link to %q outside of the uncompressed directory", target)}
+	}
+
+	err := os.Symlink(target, linkPath)
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: linkPath, Err: err}
+	}
+
+	// The target can still lead outside through symbolic links extracted earlier (as in "link_to_dot/../x").
+	resolvedTargetPath, err := filepath.EvalSymlinks(linkPath)
+	if err == nil && !isInsideDirectory(resolvedTargetPath, extraction.resolvedUncompressedDirectory) {
+		err = os.Remove(linkPath)
+		if err != nil {
+			return &PreparationError{Stage: StageUncompress, File: linkPath, Err: err}
+		}
+		return &unsafeArchiveEntryError{name: name, reason: fmt.Sprintf("is a symbolic link to %q outside of the uncompressed directory", target)}
+	}
+	return nil
+}
+
+// Targets are relative to the root of the archive and must be regular files already extracted inside.
+func (extraction *extraction) hardLink(name string, linkPath string, target string) error {
+	targetPath := filepath.Join(extraction.uncompressedDirectory, target)
+	if filepath.IsAbs(target) || !isInsideDirectory(targetPath, extraction.uncompressedDirectory) {
+		return &unsafeArchiveEntryError{name: name, reason: fmt.Sprintf("is a hard link to %q outside of the uncompressed directory", target)}
+	}
+
+	resolvedTargetPath, err := filepath.EvalSymlinks(targetPath)
+	if err != nil {
+		return &unsafeArchiveEntryError{name: name, reason: fmt.Sprintf("is a hard link to %q which is not uncompressed before it", target)}
+	}
+	if !isInsideDirectory(resolvedTargetPath, extraction.resolvedUncompressedDirectory) {
+		return &unsafeArchiveEntryError{name: name, reason: fmt.Sprintf("is a hard link to %q outside of the uncompressed directory", target)}
+	}
+	fileInfo, err := os.Stat(resolvedTargetPath)
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: resolvedTargetPath, Err: err}
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return &unsafeArchiveEntryError{name: name, reason: fmt.Sprintf("is a hard link to %q which is not a regular file", target)}
+	}
+
+	err = linkOrCopyFile(resolvedTargetPath, linkPath)
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: linkPath, Err: err}
+	}
+	return nil
+}
+
+func uncompressTar(ctx context.Context, compressedFile string, compression string, uncompressedDirectory string, options PreparationOptions) error {
+	file, err := os.Open(compressedFile)
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: compressedFile, Err: err}
+	}
+	defer file.Close()
+
+	decompressedReader, err := decompress(compression, file)
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: compressedFile, Err: err}
+	}
+
+	extraction, err := newExtraction(ctx, compressedFile, uncompressedDirectory, options)
+	if err != nil {
+		return err
+	}
+
+	// GNU long names and PAX extended headers are applied to the following entry by archive/tar itself.
+	tarReader := tar.NewReader(decompressedReader)
+
+	for true {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return &PreparationError{Stage: StageUncompress, File: compressedFile, Err: err}
+		}
+
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		err = extraction.countEntry()
+		if err == nil {
+			err = uncompressTarEntry(extraction, tarReader, header)
+		}
+		err = extraction.entryError(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func uncompressTarEntry(extraction *extraction, tarReader *tar.Reader, header *tar.Header) error {
+	entryPath, err := extraction.entryPath(header.Name)
+	if err != nil {
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return extraction.createDirectory(entryPath)
+	case tar.TypeReg, tar.TypeRegA:
+		return extraction.writeFile(entryPath, tarReader, header.Size, header.FileInfo().Mode())
+	case tar.TypeSymlink:
+		return extraction.symbolicLink(header.Name, entryPath, header.Linkname)
+	case tar.TypeLink:
+		return extraction.hardLink(header.Name, entryPath, header.Linkname)
+	default:
+		return &unsafeArchiveEntryError{name: header.Name, reason: fmt.Sprintf("has unsupported type %q", header.Typeflag)}
+	}
+}
+
+func uncompressZip(ctx context.Context, compressedFile string, uncompressedDirectory string, options PreparationOptions) error {
+	zipReader, err := zip.OpenReader(compressedFile)
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: compressedFile, Err: err}
+	}
+	defer zipReader.Close()
+
+	extraction, err := newExtraction(ctx, compressedFile, uncompressedDirectory, options)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range zipReader.File {
+		err = extraction.countEntry()
+		if err == nil {
+			err = uncompressZipEntry(extraction, file)
+		}
+		err = extraction.entryError(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func uncompressZipEntry(extraction *extraction, file *zip.File) error {
+	entryPath, err := extraction.entryPath(file.Name)
+	if err != nil {
+		return err
+	}
+
+	mode := file.Mode()
+	if mode.IsDir() {
+		return extraction.createDirectory(entryPath)
+	} else if mode&os.ModeType != 0 && mode&os.ModeSymlink == 0 {
+		return &unsafeArchiveEntryError{name: file.Name, reason: fmt.Sprintf("has unsupported mode %s", mode)}
+	}
+
+	opennedFile, err := file.Open()
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: entryPath, Err: err}
+	}
+	defer opennedFile.Close()
+
+	// The content of a symbolic link entry in a zip archive is the target of the link.
+	if mode&os.ModeSymlink != 0 {
+		target, err := io.ReadAll(io.LimitReader(opennedFile, 4096))
+		if err != nil {
+			return &PreparationError{Stage: StageUncompress, File: entryPath, Err: err}
+		}
+		return extraction.symbolicLink(file.Name, entryPath, string(target))
+	}
+
+	declaredSize := int64(file.UncompressedSize64)
+	if file.UncompressedSize64 > uint64(extraction.maximumUncompressedSize) {
+		declaredSize = extraction.maximumUncompressedSize + 1
+	}
+	return extraction.writeFile(entryPath, opennedFile, declaredSize, mode)
+}
+
+func uncompressCompressedFile(ctx context.Context, compressedFile string, compression string, uncompressedDirectory string, options PreparationOptions) error {
+	file, err := os.Open(compressedFile)
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: compressedFile, Err: err}
+	}
+	defer file.Close()
+
+	decompressedReader, err := decompress(compression, file)
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, File: compressedFile, Err: err}
+	}
+
+	extraction, err := newExtraction(ctx, compressedFile, uncompressedDirectory, options)
+	if err != nil {
+		return err
+	}
+
+	uncompressedFilePath := filepath.Join(extraction.resolvedUncompressedDirectory, filepath.Base(uncompressedDirectory))
+	err = extraction.countEntry()
+	if err == nil {
+		err = extraction.writeFile(uncompressedFilePath, decompressedReader, -1, FileMode())
+	}
+	return extraction.entryError(err)
+}
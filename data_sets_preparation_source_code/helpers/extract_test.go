@@ -0,0 +1,330 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type testArchiveEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+
+	// Tar entries only: 0644 if 0.
+	mode int64
+
+	// Declared instead of the length of the content if not 0, with the content left out.
+	declaredSize int64
+
+	// Zip entries only: written as deflated zeros of this size.
+	zeros int
+}
+
+func writeTestTarGz(t *testing.T, archivePath string, entries []testArchiveEntry) {
+	buffer := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	isComplete := true
+	for _, entry := range entries {
+		typeflag := entry.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		mode := entry.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		header := &tar.Header{Name: entry.name, Typeflag: typeflag, Linkname: entry.linkname, Mode: mode, Size: int64(len(entry.content))}
+		if typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if entry.declaredSize != 0 {
+			header.Size = entry.declaredSize
+		}
+		err := tarWriter.WriteHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.declaredSize != 0 {
+			// The content is never read, as the declared size is over the limit.
+			isComplete = false
+			break
+		}
+		if typeflag == tar.TypeReg {
+			_, err = tarWriter.Write([]byte(entry.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if isComplete {
+		if err := tarWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(archivePath, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestZip(t *testing.T, archivePath string, entries []testArchiveEntry) {
+	buffer := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(buffer)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		if entry.typeflag == tar.TypeSymlink {
+			header.SetMode(os.ModeSymlink | 0777)
+			writer, err := zipWriter.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writer.Write([]byte(entry.linkname))
+			continue
+		}
+
+		if entry.zeros == 0 && entry.declaredSize == 0 {
+			writer, err := zipWriter.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writer.Write([]byte(entry.content))
+			continue
+		}
+
+		uncompressed := make([]byte, entry.zeros)
+		compressed := &bytes.Buffer{}
+		flateWriter, _ := flate.NewWriter(compressed, flate.BestCompression)
+		flateWriter.Write(uncompressed)
+		flateWriter.Close()
+		header.CRC32 = crc32.ChecksumIEEE(uncompressed)
+		header.CompressedSize64 = uint64(compressed.Len())
+		header.UncompressedSize64 = uint64(entry.zeros)
+		if entry.declaredSize != 0 {
+			header.UncompressedSize64 = uint64(entry.declaredSize)
+		}
+		writer, err := zipWriter.CreateRaw(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write(compressed.Bytes())
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(archivePath, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The paths under root, except those in the uncompressed directory.
+func pathsOutside(t *testing.T, root string, uncompressedDirectory string) []string {
+	paths := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == uncompressedDirectory {
+			return filepath.SkipDir
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func manyTestEntries(numberOfEntries int) []testArchiveEntry {
+	entries := []testArchiveEntry{}
+	for i := 0; i < numberOfEntries; i++ {
+		entries = append(entries, testArchiveEntry{name: "file" + strconv.Itoa(i), content: "x"})
+	}
+	return entries
+}
+
+func TestMaliciousArchivesAreRejected(t *testing.T) {
+	root := t.TempDir()
+	outsideFile := filepath.Join(root, "outside_file")
+	if err := ioutil.WriteFile(outsideFile, []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "out2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		format  string
+		entries []testArchiveEntry
+		options PreparationOptions
+		isLimit bool
+	}{
+		{name: "parent directory entry", format: "tar.gz", entries: []testArchiveEntry{{name: "../evil", content: "x"}}},
+		{name: "sibling directory entry", format: "tar.gz", entries: []testArchiveEntry{{name: "../out2/evil", content: "x"}}},
+		{name: "nested parent directory entry", format: "tar.gz", entries: []testArchiveEntry{{name: "a/b/../../../evil", content: "x"}}},
+		{name: "absolute path entry", format: "tar.gz", entries: []testArchiveEntry{{name: filepath.Join(root, "evil"), content: "x"}}},
+		{name: "symbolic link to parent directory", format: "tar.gz", entries: []testArchiveEntry{
+			{name: "link", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "link/evil", content: "x"},
+		}},
+		{name: "symbolic link to absolute path", format: "tar.gz", entries: []testArchiveEntry{
+			{name: "link", typeflag: tar.TypeSymlink, linkname: root},
+			{name: "link/evil", content: "x"},
+		}},
+		{name: "symbolic link through an earlier link", format: "tar.gz", entries: []testArchiveEntry{
+			{name: "d", typeflag: tar.TypeDir},
+			{name: "d/up", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "d/up/escape", typeflag: tar.TypeSymlink, linkname: "../outside_file"},
+		}},
+		{name: "hard link to parent directory", format: "tar.gz", entries: []testArchiveEntry{{name: "link", typeflag: tar.TypeLink, linkname: "../outside_file"}}},
+		{name: "hard link to absolute path", format: "tar.gz", entries: []testArchiveEntry{{name: "link", typeflag: tar.TypeLink, linkname: outsideFile}}},
+		{name: "hard link through a symbolic link", format: "tar.gz", entries: []testArchiveEntry{
+			{name: "dot", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "link", typeflag: tar.TypeLink, linkname: "dot/../outside_file"},
+		}},
+		{name: "device file", format: "tar.gz", entries: []testArchiveEntry{{name: "device", typeflag: tar.TypeChar}}},
+		{name: "declared size over the default limit", format: "tar.gz", entries: []testArchiveEntry{{name: "bomb", declaredSize: DefaultMaximumUncompressedSize + 1}}, isLimit: true},
+		{name: "size over the limit", format: "tar.gz", entries: []testArchiveEntry{{name: "bomb", content: strings.Repeat("0", 2<<20)}}, options: PreparationOptions{MaximumUncompressedSize: 1 << 20}, isLimit: true},
+		{name: "too many entries", format: "tar.gz", entries: manyTestEntries(11), options: PreparationOptions{MaximumArchiveEntries: 10}, isLimit: true},
+		{name: "zip parent directory entry", format: "zip", entries: []testArchiveEntry{{name: "../evil", content: "x"}}},
+		{name: "zip absolute path entry", format: "zip", entries: []testArchiveEntry{{name: "/evil", content: "x"}}},
+		{name: "zip symbolic link to parent directory", format: "zip", entries: []testArchiveEntry{
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "../"},
+			{name: "link/evil", content: "x"},
+		}},
+		{name: "zip declared size over the default limit", format: "zip", entries: []testArchiveEntry{{name: "bomb", zeros: 1000, declaredSize: DefaultMaximumUncompressedSize + 1}}, isLimit: true},
+		{name: "zip bomb over the limit", format: "zip", entries: []testArchiveEntry{{name: "bomb", zeros: 10 << 20}}, options: PreparationOptions{MaximumUncompressedSize: 1 << 20}, isLimit: true},
+		{name: "zip too many entries", format: "zip", entries: manyTestEntries(11), options: PreparationOptions{MaximumArchiveEntries: 10}, isLimit: true},
+	}
+
+	for testNumber, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archivePath := filepath.Join(root, "archive"+strconv.Itoa(testNumber)+"."+test.format)
+			uncompressedDirectory := filepath.Join(root, "out")
+			if err := os.RemoveAll(uncompressedDirectory); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			if test.format == "zip" {
+				writeTestZip(t, archivePath, test.entries)
+				before := pathsOutside(t, root, uncompressedDirectory)
+				err = uncompressZip(context.Background(), archivePath, uncompressedDirectory, test.options)
+				checkNothingWrittenOutside(t, root, uncompressedDirectory, before, outsideFile)
+			} else {
+				writeTestTarGz(t, archivePath, test.entries)
+				before := pathsOutside(t, root, uncompressedDirectory)
+				err = uncompressTar(context.Background(), archivePath, compressionGzip, uncompressedDirectory, test.options)
+				checkNothingWrittenOutside(t, root, uncompressedDirectory, before, outsideFile)
+			}
+
+			var preparationError *PreparationError
+			if !errors.As(err, &preparationError) {
+				t.Fatalf("expected a PreparationError, got %v", err)
+			}
+			var limitError *archiveLimitError
+			var unsafeEntryError *unsafeArchiveEntryError
+			if test.isLimit && !errors.As(err, &limitError) {
+				t.Errorf("expected a limit error, got %v", err)
+			} else if !test.isLimit && !errors.As(err, &unsafeEntryError) {
+				t.Errorf("expected an unsafe entry error, got %v", err)
+			}
+		})
+	}
+}
+
+func checkNothingWrittenOutside(t *testing.T, root string, uncompressedDirectory string, before []string, outsideFile string) {
+	t.Helper()
+	after := pathsOutside(t, root, uncompressedDirectory)
+	if strings.Join(after, "\n") != strings.Join(before, "\n") {
+		t.Errorf("paths outside of the uncompressed directory changed from %q to %q", before, after)
+	}
+	content, err := ioutil.ReadFile(outsideFile)
+	if err != nil || string(content) != "outside" {
+		t.Errorf("file outside of the uncompressed directory changed: %q, %v", content, err)
+	}
+}
+
+func TestUnsafeArchiveEntriesAreSkipped(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "archive.tar.gz")
+	writeTestTarGz(t, archivePath, []testArchiveEntry{
+		{name: "../evil", content: "x"},
+		{name: "link", typeflag: tar.TypeSymlink, linkname: ".."},
+		{name: "safe/file", content: "safe"},
+		{name: "safe/link", typeflag: tar.TypeSymlink, linkname: "file"},
+	})
+
+	uncompressedDirectory := filepath.Join(root, "out")
+	err := uncompressTar(context.Background(), archivePath, compressionGzip, uncompressedDirectory, PreparationOptions{UnsafeArchiveEntries: UnsafeArchiveEntriesSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if paths := pathsOutside(t, root, uncompressedDirectory); len(paths) != 2 {
+		t.Errorf("paths outside of the uncompressed directory: %q", paths)
+	}
+	if _, err := os.Lstat(filepath.Join(uncompressedDirectory, "link")); !os.IsNotExist(err) {
+		t.Errorf("unsafe link is extracted: %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(uncompressedDirectory, "safe", "link"))
+	if err != nil || string(content) != "safe" {
+		t.Errorf("safe entries are not extracted: %q, %v", content, err)
+	}
+}
+
+func TestCompressedFileOverTheLimitIsRejected(t *testing.T) {
+	root := t.TempDir()
+	compressedFile := filepath.Join(root, "bomb.gz")
+	buffer := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buffer)
+	gzipWriter.Write(make([]byte, 10<<20))
+	gzipWriter.Close()
+	if err := ioutil.WriteFile(compressedFile, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uncompressedDirectory := filepath.Join(root, "out")
+	err := uncompressCompressedFile(context.Background(), compressedFile, compressionGzip, uncompressedDirectory, PreparationOptions{MaximumUncompressedSize: 1 << 20})
+	var limitError *archiveLimitError
+	if !errors.As(err, &limitError) {
+		t.Fatalf("expected a limit error, got %v", err)
+	}
+}
+
+func TestExtractionLimitsDefault(t *testing.T) {
+	extraction, err := newExtraction(context.Background(), "archive.tar.gz", filepath.Join(t.TempDir(), "out"), PreparationOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extraction.maximumUncompressedSize != DefaultMaximumUncompressedSize || extraction.maximumEntries != DefaultMaximumArchiveEntries {
+		t.Errorf("limits %d and %d, expected %d and %d", extraction.maximumUncompressedSize, extraction.maximumEntries, DefaultMaximumUncompressedSize, DefaultMaximumArchiveEntries)
+	}
+}
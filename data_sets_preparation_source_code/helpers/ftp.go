@@ -0,0 +1,304 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ftpDownloader struct {
+	timeout time.Duration
+}
+
+type ftpClient struct {
+	connection net.Conn
+	text       *textproto.Conn
+	timeout    time.Duration
+}
+
+type deadlineReader struct {
+	connection net.Conn
+	timeout    time.Duration
+}
+
+func newFTPDownloader() *ftpDownloader {
+	return &ftpDownloader{timeout: time.Minute}
+}
+
+// Passive mode only, as active mode rarely works through firewalls and NAT.
+func (downloader *ftpDownloader) attempt(ctx context.Context, rawURL string, partFilePath string, progress *downloadProgress) (fileSize int64, retryable bool, err error) {
+	offset, err := partFileSize(partFilePath)
+	if err != nil {
+		return 0, false, err
+	}
+
+	client, remotePath, retryable, err := downloader.connect(ctx, rawURL)
+	if err != nil {
+		return 0, retryable, err
+	}
+	defer client.text.Close()
+	defer closeOnCancel(ctx, client.connection)()
+
+	totalSize, err := client.size(remotePath)
+	if err != nil {
+		return 0, isRetryableFTPError(err), err
+	}
+
+	if totalSize >= 0 && offset == totalSize {
+		client.quit()
+		return offset, false, nil
+	} else if totalSize >= 0 && offset > totalSize {
+		return 0, false, discardPartFile(partFilePath, fmt.Errorf("partial file of %d bytes is larger than the remote file of %d bytes", offset, totalSize))
+	}
+
+	dataConnection, err := client.openDataConnection(ctx)
+	if err != nil {
+		return 0, isRetryableFTPError(err), err
+	}
+	defer dataConnection.Close()
+	defer closeOnCancel(ctx, dataConnection)()
+
+	code := 0
+	message := ""
+	if offset > 0 {
+		// After EPSV or PASV, as REST has to be the last command before RETR (RFC 3659).
+		code, _, err = client.command(0, "REST %d", offset)
+		if err != nil {
+			return 0, isRetryableFTPError(err), err
+		}
+		if code != 350 {
+			Log.Warning("resume_not_supported", LogFields{"url": rawURL, "offset": offset}, "| Server does not support resuming, restarting download from the beginning", rawURL)
+			offset = 0
+		} else if totalSize >= 0 {
+			Log.Info("download_resumed", LogFields{"url": rawURL, "offset": offset, "size": totalSize}, "| Resuming", rawURL, "at", offset/1024, "KB of", totalSize/1024, "KB")
+		}
+	}
+
+	code, message, err = client.command(0, "RETR %s", remotePath)
+	if err != nil {
+		return 0, isRetryableFTPError(err), err
+	}
+	if code != 125 && code != 150 {
+		return 0, code/100 == 4, &textproto.Error{Code: code, Msg: message}
+	}
+
+	fileSize, retryable, err = writePartFile(partFilePath, offset, totalSize, deadlineReader{connection: dataConnection, timeout: client.timeout}, progress, rawURL)
+	dataConnection.Close()
+	if err != nil {
+		return fileSize, retryable, err
+	}
+
+	err = client.connection.SetDeadline(time.Now().Add(client.timeout))
+	if err != nil {
+		return fileSize, true, err
+	}
+	_, _, err = client.text.ReadResponse(2)
+	if err != nil {
+		return fileSize, true, err
+	}
+
+	client.quit()
+	return fileSize, false, nil
+}
+
+// Logs in anonymously unless the URL has a user. The caller closes the returned client.
+func (downloader *ftpDownloader) connect(ctx context.Context, rawURL string) (*ftpClient, string, bool, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	address := parsedURL.Host
+	if parsedURL.Port() == "" {
+		address = net.JoinHostPort(parsedURL.Hostname(), "21")
+	}
+	user := "anonymous"
+	password := "anonymous@"
+	if parsedURL.User != nil {
+		user = parsedURL.User.Username()
+		userPassword, hasPassword := parsedURL.User.Password()
+		if hasPassword {
+			password = userPassword
+		}
+	}
+	remotePath := parsedURL.Path
+
+	dialer := net.Dialer{Timeout: downloader.timeout}
+	connection, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, "", true, err
+	}
+	client := &ftpClient{connection: connection, text: textproto.NewConn(connection), timeout: downloader.timeout}
+
+	stop := closeOnCancel(ctx, connection)
+	retryable, err := client.login(user, password)
+	stop()
+	if err != nil {
+		client.text.Close()
+		return nil, "", retryable, err
+	}
+	return client, remotePath, false, nil
+}
+
+func (client *ftpClient) login(user string, password string) (retryable bool, err error) {
+	err = client.connection.SetDeadline(time.Now().Add(client.timeout))
+	if err != nil {
+		return true, err
+	}
+	_, _, err = client.text.ReadResponse(220)
+	if err != nil {
+		return isRetryableFTPError(err), err
+	}
+
+	code, message, err := client.command(0, "USER %s", user)
+	if err == nil && code == 331 {
+		code, message, err = client.command(0, "PASS %s", password)
+	}
+	if err != nil {
+		return isRetryableFTPError(err), err
+	}
+	if code != 230 && code != 202 {
+		return code/100 == 4, &textproto.Error{Code: code, Msg: "login failed: " + message}
+	}
+
+	_, _, err = client.command(200, "TYPE I")
+	if err != nil {
+		return isRetryableFTPError(err), err
+	}
+	return false, nil
+}
+
+func (downloader *ftpDownloader) size(ctx context.Context, rawURL string) (int64, error) {
+	client, remotePath, _, err := downloader.connect(ctx, rawURL)
+	if err != nil {
+		return -1, err
+	}
+	defer client.text.Close()
+	defer closeOnCancel(ctx, client.connection)()
+
+	totalSize, err := client.size(remotePath)
+	if err != nil {
+		return -1, err
+	}
+	client.quit()
+	return totalSize, nil
+}
+
+// Returns -1 if the server does not support the SIZE command.
+func (client *ftpClient) size(remotePath string) (int64, error) {
+	code, message, err := client.command(0, "SIZE %s", remotePath)
+	if err != nil {
+		return -1, err
+	}
+	if code != 213 {
+		return -1, nil
+	}
+	totalSize, err := strconv.ParseInt(strings.TrimSpace(message), 10, 64)
+	if err != nil {
+		return -1, fmt.Errorf("invalid SIZE response %q", message)
+	}
+	return totalSize, nil
+}
+
+func (client *ftpClient) command(expectCode int, format string, arguments ...interface{}) (code int, message string, err error) {
+	err = client.connection.SetDeadline(time.Now().Add(client.timeout))
+	if err != nil {
+		return 0, "", err
+	}
+
+	id, err := client.text.Cmd(format, arguments...)
+	if err != nil {
+		return 0, "", err
+	}
+	client.text.StartResponse(id)
+	defer client.text.EndResponse(id)
+
+	return client.text.ReadResponse(expectCode)
+}
+
+// The host of a PASV reply is ignored, as servers behind NAT often report a private address.
+func (client *ftpClient) openDataConnection(ctx context.Context) (net.Conn, error) {
+	port := 0
+	code, message, err := client.command(0, "EPSV")
+	if err != nil {
+		return nil, err
+	}
+
+	if code == 229 {
+		start := strings.Index(message, "(")
+		end := strings.LastIndex(message, ")")
+		if start == -1 || end < start+2 {
+			return nil, fmt.Errorf("invalid EPSV response %q", message)
+		}
+		fields := strings.Split(message[start+1:end], message[start+1:start+2])
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("invalid EPSV response %q", message)
+		}
+		port, err = strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid EPSV response %q", message)
+		}
+	} else {
+		_, message, err = client.command(227, "PASV")
+		if err != nil {
+			return nil, err
+		}
+		start := strings.Index(message, "(")
+		end := strings.LastIndex(message, ")")
+		if start == -1 || end < start {
+			return nil, fmt.Errorf("invalid PASV response %q", message)
+		}
+		fields := strings.Split(message[start+1:end], ",")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid PASV response %q", message)
+		}
+		portHigh, errHigh := strconv.Atoi(strings.TrimSpace(fields[4]))
+		portLow, errLow := strconv.Atoi(strings.TrimSpace(fields[5]))
+		if errHigh != nil || errLow != nil {
+			return nil, fmt.Errorf("invalid PASV response %q", message)
+		}
+		port = portHigh*256 + portLow
+	}
+
+	host, _, err := net.SplitHostPort(client.connection.RemoteAddr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{Timeout: client.timeout}
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+func (client *ftpClient) quit() {
+	client.command(0, "QUIT")
+}
+
+func (reader deadlineReader) Read(buffer []byte) (int, error) {
+	err := reader.connection.SetReadDeadline(time.Now().Add(reader.timeout))
+	if err != nil {
+		return 0, err
+	}
+	return reader.connection.Read(buffer)
+}
+
+func isRetryableFTPError(err error) bool {
+	var ftpError *textproto.Error
+	if errors.As(err, &ftpError) {
+		return ftpError.Code/100 == 4
+	}
+	return isRetryableNetworkError(err)
+}
@@ -0,0 +1,257 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A minimal FTP server serving a single file, for the commands the downloader sends.
+type fakeFTPServer struct {
+	listener net.Listener
+	content  []byte
+
+	// Reported by SIZE instead of the length of the content if not -1.
+	reportedSize int
+	passiveOnly  bool
+
+	mutex    sync.Mutex
+	commands []string
+}
+
+func newFakeFTPServer(t *testing.T, content []byte) *fakeFTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeFTPServer{listener: listener, content: content, reportedSize: -1}
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(connection)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (server *fakeFTPServer) url() string {
+	return "ftp://" + server.listener.Addr().String() + "/data/file.bin"
+}
+
+func (server *fakeFTPServer) receivedCommands() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]string(nil), server.commands...)
+}
+
+func (server *fakeFTPServer) serve(connection net.Conn) {
+	defer connection.Close()
+	reader := bufio.NewReader(connection)
+	reply := func(format string, a ...interface{}) {
+		fmt.Fprintf(connection, format+"\r\n", a...)
+	}
+
+	var dataListener net.Listener
+	defer func() {
+		if dataListener != nil {
+			dataListener.Close()
+		}
+	}()
+	offset := 0
+	previousCommand := ""
+
+	reply("220 fake FTP server")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		server.mutex.Lock()
+		server.commands = append(server.commands, line)
+		server.mutex.Unlock()
+		command, argument := line, ""
+		if spaceIndex := strings.Index(line, " "); spaceIndex != -1 {
+			command, argument = line[:spaceIndex], line[spaceIndex+1:]
+		}
+
+		switch command {
+		case "USER":
+			reply("331 password please")
+		case "PASS":
+			reply("230 logged in")
+		case "TYPE":
+			reply("200 binary")
+		case "SIZE":
+			size := len(server.content)
+			if server.reportedSize != -1 {
+				size = server.reportedSize
+			}
+			reply("213 %d", size)
+		case "EPSV", "PASV":
+			if command == "EPSV" && server.passiveOnly {
+				reply("502 EPSV not implemented")
+				break
+			}
+			dataListener, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				reply("425 cannot listen")
+				break
+			}
+			port := dataListener.Addr().(*net.TCPAddr).Port
+			if command == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				reply("227 Entering Passive Mode (127,0,0,1,%d,%d)", port/256, port%256)
+			}
+		case "REST":
+			offset, err = strconv.Atoi(argument)
+			if err != nil || offset > len(server.content) {
+				reply("501 invalid offset")
+				offset = 0
+				break
+			}
+			reply("350 restarting at %d", offset)
+		case "RETR":
+			// RFC 3659: REST applies only to the transfer command right after it.
+			if previousCommand != "REST" {
+				offset = 0
+			}
+			if dataListener == nil {
+				reply("425 use EPSV or PASV first")
+				break
+			}
+			reply("150 opening data connection")
+			dataConnection, err := dataListener.Accept()
+			if err != nil {
+				return
+			}
+			dataConnection.Write(server.content[offset:])
+			dataConnection.Close()
+			dataListener.Close()
+			dataListener = nil
+			offset = 0
+			reply("226 transfer complete")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 %s not implemented", command)
+		}
+		previousCommand = command
+	}
+}
+
+func TestFTPDownload(t *testing.T) {
+	content := testContent(100000)
+	tests := []struct {
+		name         string
+		passiveOnly  bool
+		partialBytes int
+		reportedSize int
+		expectError  bool
+
+		expectedCommand  string
+		unexpectedPrefix string
+	}{
+		{name: "fresh download", reportedSize: -1, expectedCommand: "EPSV", unexpectedPrefix: "REST"},
+		{name: "resumed with REST", partialBytes: 40000, reportedSize: -1, expectedCommand: "REST 40000"},
+		{name: "PASV only server", passiveOnly: true, reportedSize: -1, expectedCommand: "PASV"},
+		{name: "size mismatch", reportedSize: len(content) - 10, expectError: true, expectedCommand: "SIZE /data/file.bin"},
+		{name: "partial file larger than the remote file", partialBytes: len(content), reportedSize: len(content) - 10, expectError: true, expectedCommand: "SIZE /data/file.bin", unexpectedPrefix: "RETR"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeFTPServer(t, content)
+			server.passiveOnly = test.passiveOnly
+			server.reportedSize = test.reportedSize
+
+			directory := t.TempDir()
+			partFilePath := filepath.Join(directory, "file.bin.part")
+			filePath := filepath.Join(directory, "file.bin")
+			if test.partialBytes > 0 {
+				err := ioutil.WriteFile(partFilePath, content[:test.partialBytes], 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := downloadWithRetries(context.Background(), newFTPDownloader(), testRetryPolicy, server.url(), partFilePath, filePath, nil)
+			commands := server.receivedCommands()
+			if !containsString(commands, test.expectedCommand) {
+				t.Errorf("no %q in the commands %q", test.expectedCommand, commands)
+			}
+			for i, command := range commands {
+				if test.unexpectedPrefix != "" && strings.HasPrefix(command, test.unexpectedPrefix) {
+					t.Errorf("unexpected %q in the commands %q", command, commands)
+				}
+				if strings.HasPrefix(command, "REST") && (i+1 == len(commands) || !strings.HasPrefix(commands[i+1], "RETR")) {
+					t.Errorf("REST is not right before RETR in the commands %q", commands)
+				}
+			}
+
+			if test.expectError {
+				var preparationError *PreparationError
+				if !errors.As(err, &preparationError) {
+					t.Fatalf("expected a PreparationError, got %v", err)
+				}
+				numberOfAttempts := 0
+				for _, command := range commands {
+					if strings.HasPrefix(command, "SIZE") {
+						numberOfAttempts++
+					}
+				}
+				if numberOfAttempts != 1 {
+					t.Errorf("%d attempts, expected the error not to be retried", numberOfAttempts)
+				}
+				if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+					t.Errorf("file of a failed download exists: %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			downloaded, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(downloaded, content) {
+				t.Errorf("downloaded %d bytes that differ from the %d bytes served", len(downloaded), len(content))
+			}
+		})
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -9,221 +9,634 @@
 package helpers
 
 import (
-	"archive/tar"
-	"archive/zip"
-	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
 type DataSetPreparationInformation struct {
+	Name                      string
 	PrefixOfInputDownloadURLs string
 	InputDownloadURLs         []string
 	Parameters                []string
+	Settings                  map[string]interface{} // Settings resolved beyond the parameters (such as thresholds), recorded in the report.
+	InputChecksums            map[string]string
 	Preparation               interface {
-		Prepare(dataSetPreparationInformation *DataSetPreparationInformation, outputDirectory string)
+		Prepare(ctx context.Context, dataSetPreparationInformation *DataSetPreparationInformation, outputDirectory string) error
 	}
 	OnlySpecificPreparation bool
+	Options                 PreparationOptions
+
+	// Set while Prepare runs, for preparations to add the versions of the tools they run.
+	Report *PreparationReport
+}
+
+type PreparationOptions struct {
+	Resume         bool
+	ForcedStages   []string
+	Checksums      map[string]string
+	Mirrors        map[string]string
+	LocalFiles     string
+	CacheDirectory string
+
+	UnsafeArchiveEntries    string
+	MaximumUncompressedSize int64
+	MaximumArchiveEntries   int
+
+	MaximumConcurrentDownloads int
+
+	// Debug, info, warning or error. The run log gets every message.
+	LogLevel string
+
+	// Octal umask of the written directories and files (022 if empty).
+	Umask string
+
+	// OutputFormatCSV (if empty) or OutputFormatTSV, for the tables written into Final_files.
+	OutputFormat string
+
+	// Only prints what would be done and checks the disk space.
+	DryRun bool
+
+	// Written to the output directory as preparation_config.json.
+	ResolvedConfig []byte
+}
+
+const ResolvedConfigFileName = "preparation_config.json"
+
+const (
+	OutputFormatCSV = "csv"
+	OutputFormatTSV = "tsv"
+)
+
+// The file name of a table of Final_files in the output format.
+func OutputFileName(name string, outputFormat string) string {
+	if outputFormat == "" {
+		outputFormat = OutputFormatCSV
+	}
+	return name + "." + outputFormat
 }
 
-func (dataSetPreparationInformation *DataSetPreparationInformation) Prepare(outputDirectory string) {
+// Tabs for .tsv files and commas otherwise.
+func OutputSeparatorOf(filePath string) string {
+	if strings.EqualFold(filepath.Ext(filePath), "."+OutputFormatTSV) {
+		return "\t"
+	}
+	return ","
+}
+
+type preparationInput struct {
+	inputDownloadURL string
+	url              string
+	relativePath     string
+	localPath        string
+	checksum         string
+}
+
+// Cancelling the context stops the running stage, keeping partial downloads for a resumed run. Completed stages stay completed.
+func (dataSetPreparationInformation *DataSetPreparationInformation) Prepare(ctx context.Context, outputDirectory string) error {
+	options := dataSetPreparationInformation.Options
 
 	if !dataSetPreparationInformation.OnlySpecificPreparation {
 		_, err := os.Stat(outputDirectory)
-		if !os.IsNotExist(err) {
-			panic("Not finished successfully. Output directory already exists.")
-		}
-
-		os.MkdirAll(outputDirectory, 600)
-		os.MkdirAll(filepath.Join(outputDirectory, "Downloaded_files"), 600)
-		os.MkdirAll(filepath.Join(outputDirectory, "Uncompressed_downloaded_files"), 600)
-		os.MkdirAll(filepath.Join(outputDirectory, "Temporary_files"), 600)
-		os.MkdirAll(filepath.Join(outputDirectory, "Final_files"), 600)
-
-		for _, inputDownloadURL := range dataSetPreparationInformation.InputDownloadURLs {
-			url := dataSetPreparationInformation.PrefixOfInputDownloadURLs + inputDownloadURL
-			relativePath := inputDownloadURL
-			if strings.HasPrefix(inputDownloadURL, "https://") ||
-				strings.HasPrefix(inputDownloadURL, "http://") ||
-				strings.HasPrefix(inputDownloadURL, "ftp://") {
-				url = inputDownloadURL
-				relativePath = path.Base(url)
-			}
+		if !os.IsNotExist(err) && !options.Resume {
+			return &PreparationError{Stage: StageSetup, File: outputDirectory, Err: errors.New("output directory already exists (use --resume to continue a previous run)")}
+		}
+	}
 
-			filePath := filepath.Join(outputDirectory, "Downloaded_files", relativePath)
-			os.MkdirAll(filepath.Dir(filePath), 600)
-			downloadFile(url, filePath)
-
-			if strings.HasSuffix(filePath, ".tar.gz") {
-				fmt.Println("Uncompressing...", filepath.Base(filePath))
-				uncompressedDirectory := filepath.Dir(filepath.Join(outputDirectory, "Uncompressed_downloaded_files", relativePath))
-				uncompressedDirectory = filepath.Join(uncompressedDirectory, strings.TrimSuffix(filepath.Base(filePath), ".tar.gz"))
-				_, err := os.Stat(uncompressedDirectory)
-				if !os.IsNotExist(err) {
-					panic("Not finished successfully.")
-				}
-				uncompressTarGz(filePath, uncompressedDirectory)
-				fmt.Println("| Uncompressed", filepath.Base(filePath))
-				fmt.Println()
+	// The umask and the log level of the options apply while the preparation runs only.
+	defer setUmask(currentUmask())
+	defer Log.SetLevel(Log.currentLevel())
+
+	if dataSetPreparationInformation.InputChecksums == nil {
+		dataSetPreparationInformation.InputChecksums = registeredInputChecksums(dataSetPreparationInformation.Name)
+	}
+	inputs := dataSetPreparationInformation.inputs()
+	err := dataSetPreparationInformation.validateOptions(inputs)
+	if err != nil {
+		return err
+	}
+
+	if options.DryRun {
+		state, err := loadPreparationState(outputDirectory)
+		if err != nil {
+			return err
+		}
+		return dataSetPreparationInformation.printPlan(ctx, outputDirectory, inputs, state)
+	}
+
+	if !dataSetPreparationInformation.OnlySpecificPreparation {
+		for _, directory := range []string{
+			outputDirectory,
+			filepath.Join(outputDirectory, "Downloaded_files"),
+			filepath.Join(outputDirectory, "Uncompressed_downloaded_files"),
+			filepath.Join(outputDirectory, "Temporary_files"),
+			filepath.Join(outputDirectory, "Final_files")} {
+			err = os.MkdirAll(directory, DirectoryMode())
+			if err != nil {
+				return &PreparationError{Stage: StageSetup, File: directory, Err: err}
 			}
+		}
 
-			if strings.HasSuffix(filePath, ".zip") {
-				fmt.Println("Uncompressing...", filepath.Base(filePath))
-				uncompressedDirectory := filepath.Dir(filepath.Join(outputDirectory, "Uncompressed_downloaded_files", relativePath))
-				uncompressedDirectory = filepath.Join(uncompressedDirectory, strings.TrimSuffix(filepath.Base(filePath), ".zip"))
-				_, err := os.Stat(uncompressedDirectory)
-				if !os.IsNotExist(err) {
-					panic("Not finished successfully.")
-				}
-				uncompressZip(filePath, uncompressedDirectory)
-				fmt.Println("| Uncompressed", filepath.Base(filePath))
-				fmt.Println()
+		if options.ResolvedConfig != nil {
+			err = writeFileAtomically(filepath.Join(outputDirectory, ResolvedConfigFileName), options.ResolvedConfig)
+			if err != nil {
+				return &PreparationError{Stage: StageSetup, File: filepath.Join(outputDirectory, ResolvedConfigFileName), Err: err}
 			}
 		}
 	}
 
-	if dataSetPreparationInformation.Preparation != nil {
-		fmt.Println("Processing further ...")
-		dataSetPreparationInformation.Preparation.Prepare(dataSetPreparationInformation, outputDirectory)
-		fmt.Println("| Processed further ...")
+	dataSetPreparationInformation.Report = newPreparationReport(dataSetPreparationInformation)
+	err = Log.openRunLog(outputDirectory, dataSetPreparationInformation.Report)
+	if err != nil {
+		return &PreparationError{Stage: StageSetup, File: filepath.Join(outputDirectory, RunLogFileName), Err: err}
 	}
-}
-
-func downloadFile(url string, filePath string) {
-	headResponse, err := http.Head(url)
-	defer headResponse.Body.Close()
+	defer Log.closeRunLog()
+
+	Log.Debug(EventRunStarted, LogFields{
+		"preparation":                   dataSetPreparationInformation.Name,
+		"output_directory":              outputDirectory,
+		"prefix_of_input_download_urls": dataSetPreparationInformation.PrefixOfInputDownloadURLs,
+		"input_download_urls":           dataSetPreparationInformation.InputDownloadURLs,
+		"parameters":                    dataSetPreparationInformation.Parameters,
+		"settings":                      dataSetPreparationInformation.Settings,
+		"resume":                        options.Resume,
+		"forced_stages":                 options.ForcedStages},
+		"Preparing", dataSetPreparationInformation.Name, "in", outputDirectory)
+
+	err = dataSetPreparationInformation.run(ctx, outputDirectory, inputs)
 	if err != nil {
-		panic("Not finished successfully.")
+		fields := LogFields{"preparation": dataSetPreparationInformation.Name, "succeeded": false, "error": err.Error()}
+		var preparationError *PreparationError
+		if errors.As(err, &preparationError) {
+			fields["stage"] = preparationError.Stage
+			fields["url"] = preparationError.URL
+			fields["file"] = preparationError.File
+		}
+		Log.Error(EventRunFinished, fields)
+		return err
 	}
+	Log.Debug(EventRunFinished, LogFields{"preparation": dataSetPreparationInformation.Name, "succeeded": true})
+	return nil
+}
 
-	urlFileSize := headResponse.ContentLength
-	fmt.Println(time.Now().Format(time.UnixDate))
-	fmt.Println("Downloading...", url, "|Size ~=", urlFileSize/1024, "KB")
+func (dataSetPreparationInformation *DataSetPreparationInformation) run(ctx context.Context, outputDirectory string, inputs []preparationInput) error {
+	options := dataSetPreparationInformation.Options
 
-	file, err := os.Create(filePath)
-	defer file.Close()
+	state, err := loadPreparationState(outputDirectory)
 	if err != nil {
-		panic("Not finished successfully.")
+		return err
+	}
+	for _, forcedStage := range options.ForcedStages {
+		err = state.forget(forcedStage)
+		if err != nil {
+			return err
+		}
 	}
 
-	response, err := http.Get(url)
-	defer response.Body.Close()
-	if err != nil {
-		panic("Not finished successfully.")
+	if !dataSetPreparationInformation.OnlySpecificPreparation {
+		run := &preparationRun{ctx: ctx, outputDirectory: outputDirectory, options: options, state: state, report: dataSetPreparationInformation.Report}
+
+		run.manifest, err = LoadManifest(outputDirectory)
+		if err != nil {
+			return err
+		}
+
+		if options.CacheDirectory != "" {
+			run.cache, err = OpenDownloadCache(options.CacheDirectory)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = run.downloadInputs(inputs)
+		if err != nil {
+			return err
+		}
+
+		for _, input := range inputs {
+			err = run.uncompressInput(input)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	fileSize, err := io.Copy(file, response.Body)
+	// Written only when the processing stage runs, so that a resumed run keeps the statistics of the run that did.
+	if dataSetPreparationInformation.Preparation != nil {
+		processingStage := dataSetPreparationInformation.processingStage()
+		if options.Resume && state.isCompleted(processingStage) {
+			Log.Info("stage_skipped", LogFields{"stage": processingStage}, "Skipping completed stage", processingStage)
+			return nil
+		}
 
-	if fileSize != urlFileSize {
-		panic("Not finished successfully.")
+		if ctx.Err() != nil {
+			return &PreparationError{Stage: StageProcessing, Err: ctx.Err()}
+		}
+
+		startedAt := time.Now()
+		Log.Info("processing_started", LogFields{"stage": processingStage}, "Processing further ...")
+		err := dataSetPreparationInformation.Preparation.Prepare(ctx, dataSetPreparationInformation, outputDirectory)
+		if err != nil {
+			if ctx.Err() != nil {
+				dataSetPreparationInformation.removeFinalFiles(outputDirectory)
+			}
+			return err
+		}
+		err = state.markCompleted(processingStage)
+		if err != nil {
+			return err
+		}
+		dataSetPreparationInformation.Report.recordStage(processingStage, startedAt, false)
+		Log.Info("processing_finished", LogFields{"stage": processingStage}, "| Processed further ...")
+
+		err = dataSetPreparationInformation.Report.save(outputDirectory)
+		if err != nil {
+			return err
+		}
 	}
 
-	fmt.Println("| Downloaded", url, "| Size~= ", fileSize/1024, "KB")
-	fmt.Println()
+	return nil
 }
 
-func uncompressTarGz(compressedFile string, uncompressedDirectory string) {
-	file, err := os.Open(compressedFile)
+// Runs before anything is created, for dry runs too.
+func (dataSetPreparationInformation *DataSetPreparationInformation) validateOptions(inputs []preparationInput) error {
+	options := dataSetPreparationInformation.Options
+
+	err := Log.SetLevel(options.LogLevel)
 	if err != nil {
-		panic("Not finished successfully.")
+		return &PreparationError{Stage: StageArguments, Err: err}
 	}
 
-	gzipReader, err := gzip.NewReader(file)
+	err = SetUmask(options.Umask)
 	if err != nil {
-		panic("Not finished successfully.")
+		return &PreparationError{Stage: StageArguments, Err: err}
 	}
 
-	tarReader := tar.NewReader(gzipReader)
+	switch options.OutputFormat {
+	case "", OutputFormatCSV, OutputFormatTSV:
+	default:
+		return &PreparationError{Stage: StageArguments, Err: fmt.Errorf("unknown output format %q (expected %s or %s)", options.OutputFormat, OutputFormatCSV, OutputFormatTSV)}
+	}
 
-	for true {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			panic("Not finished successfully.")
+	stages := dataSetPreparationInformation.Stages()
+	for _, forcedStage := range options.ForcedStages {
+		isKnownStage := false
+		for _, stage := range stages {
+			if stage == forcedStage {
+				isKnownStage = true
+			}
+		}
+		if !isKnownStage {
+			return &PreparationError{Stage: StageArguments, Err: fmt.Errorf("unknown stage %q to force (stages: %s)", forcedStage, strings.Join(stages, ", "))}
 		}
+	}
 
-		if header.Typeflag == tar.TypeDir {
-			directory := filepath.Join(uncompressedDirectory, header.Name)
-			if !strings.HasPrefix(directory, uncompressedDirectory) {
-				panic("Not finished successfully.")
-			}
-			os.MkdirAll(directory, 600)
-		} else if header.Typeflag == tar.TypeReg {
-			filePath := filepath.Join(uncompressedDirectory, header.Name)
-			if !strings.HasPrefix(filePath, uncompressedDirectory) {
-				panic("Not finished successfully.")
-			}
+	if dataSetPreparationInformation.OnlySpecificPreparation {
+		return nil
+	}
+
+	switch options.LocalFiles {
+	case "", LocalFilesHardLink, LocalFilesSymbolicLink, LocalFilesCopy:
+	default:
+		return &PreparationError{Stage: StageArguments, Err: fmt.Errorf("unknown way of using local files %q (expected %s, %s or %s)", options.LocalFiles, LocalFilesHardLink, LocalFilesSymbolicLink, LocalFilesCopy)}
+	}
+
+	switch options.UnsafeArchiveEntries {
+	case "", UnsafeArchiveEntriesReject, UnsafeArchiveEntriesSkip:
+	default:
+		return &PreparationError{Stage: StageArguments, Err: fmt.Errorf("unknown policy for unsafe archive entries %q (expected %s or %s)", options.UnsafeArchiveEntries, UnsafeArchiveEntriesReject, UnsafeArchiveEntriesSkip)}
+	}
+
+	if options.MaximumUncompressedSize < 0 {
+		return &PreparationError{Stage: StageArguments, Err: fmt.Errorf("maximum uncompressed size %d is negative", options.MaximumUncompressedSize)}
+	}
+	if options.MaximumArchiveEntries < 0 {
+		return &PreparationError{Stage: StageArguments, Err: fmt.Errorf("maximum number of archive entries %d is negative", options.MaximumArchiveEntries)}
+	}
+
+	if options.MaximumConcurrentDownloads < 0 {
+		return &PreparationError{Stage: StageArguments, Err: fmt.Errorf("maximum number of concurrent downloads %d is negative", options.MaximumConcurrentDownloads)}
+	}
 
-			uncompressedFile, err := os.Create(filePath)
+	for _, input := range inputs {
+		if input.checksum != "" {
+			_, _, err := parseChecksum(input.checksum)
 			if err != nil {
-				panic("Not finished successfully.")
+				return &PreparationError{Stage: StageArguments, URL: input.url, Err: err}
 			}
-			fileSize, err := io.Copy(uncompressedFile, tarReader)
+		}
+	}
+
+	return nil
+}
+
+type preparationRun struct {
+	ctx             context.Context
+	outputDirectory string
+	options         PreparationOptions
+	state           *preparationState
+	manifest        *Manifest
+	cache           *DownloadCache
+	progress        *downloadProgress
+	report          *PreparationReport
+}
+
+// The first error cancels the other downloads and is returned once they have stopped.
+func (run *preparationRun) downloadInputs(inputs []preparationInput) error {
+	maximumConcurrentDownloads := run.options.MaximumConcurrentDownloads
+	if maximumConcurrentDownloads == 0 {
+		maximumConcurrentDownloads = DefaultMaximumConcurrentDownloads
+	}
+
+	run.progress = newDownloadProgress()
+	for _, input := range inputs {
+		if !(run.options.Resume && run.state.isCompleted(StageDownload+":"+input.relativePath)) && input.localPath == "" {
+			run.progress.addFile(input.url)
+		}
+	}
+	if len(run.progress.files) != 0 {
+		Log.Info(EventMessage, nil, time.Now().Format(time.UnixDate))
+		Log.Info("downloads_started", LogFields{"number_of_files": len(run.progress.files), "maximum_concurrent_downloads": maximumConcurrentDownloads}, "Downloading...", len(run.progress.files), "files | At most", maximumConcurrentDownloads, "at the same time")
+	}
+	run.progress.start(DownloadProgressInterval)
+
+	ctx, cancel := context.WithCancel(run.ctx)
+	defer cancel()
+
+	var firstError error
+	firstErrorMutex := sync.Mutex{}
+	semaphore := make(chan struct{}, maximumConcurrentDownloads)
+	waitGroup := sync.WaitGroup{}
+	for _, input := range inputs {
+		waitGroup.Add(1)
+		go func(input preparationInput) {
+			defer waitGroup.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := run.downloadInput(ctx, input)
 			if err != nil {
-				panic("Not finished successfully.")
+				firstErrorMutex.Lock()
+				if firstError == nil {
+					firstError = err
+					cancel()
+				}
+				firstErrorMutex.Unlock()
 			}
-			if fileSize != header.FileInfo().Size() {
-				panic("Not finished successfully.")
+		}(input)
+	}
+	waitGroup.Wait()
+	run.progress.stop()
+
+	if firstError == nil && run.ctx.Err() != nil {
+		return &PreparationError{Stage: StageDownload, Err: run.ctx.Err()}
+	}
+	return firstError
+}
+
+func (run *preparationRun) downloadInput(ctx context.Context, input preparationInput) error {
+	filePath := filepath.Join(run.outputDirectory, "Downloaded_files", input.relativePath)
+
+	startedAt := time.Now()
+	downloadStage := StageDownload + ":" + input.relativePath
+	if run.options.Resume && run.state.isCompleted(downloadStage) {
+		Log.Info("stage_skipped", LogFields{"stage": downloadStage}, "Skipping completed stage", downloadStage)
+		_, err := verifyAndRecordInput(run.manifest, input, run.outputDirectory, filePath, run.manifest.Entry(manifestFilePath(run.outputDirectory, filePath)))
+		if err != nil {
+			forgetErr := run.state.forget(downloadStage)
+			if forgetErr != nil {
+				return forgetErr
 			}
+			return err
+		}
+		run.report.recordStage(downloadStage, startedAt, true)
+		return nil
+	}
 
-			uncompressedFile.Close()
-		} else {
-			panic("Not finished successfully.")
+	err := os.MkdirAll(filepath.Dir(filePath), DirectoryMode())
+	if err != nil {
+		return &PreparationError{Stage: StageDownload, URL: input.url, File: filepath.Dir(filePath), Err: err}
+	}
+	partFilePath := filepath.Join(run.outputDirectory, "Temporary_files", "Partial_downloads", input.relativePath+".part")
+	if !run.options.Resume {
+		err = os.Remove(partFilePath)
+		if err != nil && !os.IsNotExist(err) {
+			return &PreparationError{Stage: StageDownload, URL: input.url, File: partFilePath, Err: err}
 		}
 	}
 
+	var knownEntry *ManifestEntry
+	isCached := false
+	if input.localPath != "" {
+		err = copyLocalFile(ctx, input.localPath, partFilePath, filePath, run.options.LocalFiles)
+	} else if run.cache != nil {
+		knownEntry, err = run.cache.linkInto(input.url, input.checksum, filePath)
+		isCached = knownEntry != nil
+		if isCached {
+			run.progress.finishFile(input.url, knownEntry.Size)
+		} else if err == nil {
+			err = downloadFile(ctx, input.url, partFilePath, filePath, run.progress)
+		}
+	} else {
+		err = downloadFile(ctx, input.url, partFilePath, filePath, run.progress)
+	}
+	if err != nil {
+		return err
+	}
+
+	entry, err := verifyAndRecordInput(run.manifest, input, run.outputDirectory, filePath, knownEntry)
+	if err != nil {
+		return err
+	}
+	if run.cache != nil && input.localPath == "" && !isCached {
+		err = run.cache.add(*entry, filePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = run.state.markCompleted(downloadStage)
+	if err != nil {
+		return err
+	}
+	run.report.recordStage(downloadStage, startedAt, false)
+	return nil
 }
 
-func uncompressZip(compressedFile string, uncompressedDirectory string) {
-	zipReader, err := zip.OpenReader(compressedFile)
+func (run *preparationRun) uncompressInput(input preparationInput) error {
+	filePath := filepath.Join(run.outputDirectory, "Downloaded_files", input.relativePath)
+
+	if archiveFormat, _ := archiveFormatByExtension(filePath); archiveFormat == nil {
+		return nil
+	}
+
+	startedAt := time.Now()
+	uncompressStage := StageUncompress + ":" + input.relativePath
+	if run.options.Resume && run.state.isCompleted(uncompressStage) {
+		Log.Info("stage_skipped", LogFields{"stage": uncompressStage}, "Skipping completed stage", uncompressStage)
+		run.report.recordStage(uncompressStage, startedAt, true)
+		return nil
+	}
+
+	archiveFormat, archiveExtension, err := detectArchiveFormat(filePath)
+	if err != nil {
+		return &PreparationError{Stage: StageUncompress, URL: input.url, File: filePath, Err: err}
+	}
+
+	Log.Info("uncompressing", LogFields{"file": filePath, "format": archiveFormat.Name}, "Uncompressing...", filepath.Base(filePath))
+	uncompressedDirectory := filepath.Dir(filepath.Join(run.outputDirectory, "Uncompressed_downloaded_files", input.relativePath))
+	uncompressedDirectory = filepath.Join(uncompressedDirectory, strings.TrimSuffix(filepath.Base(filePath), archiveExtension))
+	_, err = os.Stat(uncompressedDirectory)
+	if !os.IsNotExist(err) {
+		if !run.options.Resume {
+			return &PreparationError{Stage: StageUncompress, File: uncompressedDirectory, Err: errors.New("uncompressed directory already exists")}
+		}
+		err = os.RemoveAll(uncompressedDirectory)
+		if err != nil {
+			return &PreparationError{Stage: StageUncompress, File: uncompressedDirectory, Err: err}
+		}
+	}
+	err = archiveFormat.Uncompress(run.ctx, filePath, uncompressedDirectory, run.options)
 	if err != nil {
-		panic("Not finished successfully.")
+		if run.ctx.Err() != nil {
+			os.RemoveAll(uncompressedDirectory)
+		}
+		return err
+	}
+	err = run.state.markCompleted(uncompressStage)
+	if err != nil {
+		return err
+	}
+	run.report.recordStage(uncompressStage, startedAt, false)
+	Log.Info("uncompressed", LogFields{"file": filePath, "directory": uncompressedDirectory}, "| Uncompressed", filepath.Base(filePath))
+	Log.EmptyLine()
+	return nil
+}
+
+func (dataSetPreparationInformation *DataSetPreparationInformation) removeFinalFiles(outputDirectory string) {
+	plannedPreparation, ok := dataSetPreparationInformation.Preparation.(PlannedPreparation)
+	if !ok {
+		return
+	}
+	for _, finalFile := range plannedPreparation.Plan(dataSetPreparationInformation, outputDirectory).FinalFiles {
+		err := os.Remove(filepath.Join(outputDirectory, finalFile))
+		if err == nil {
+			Log.Info("file_removed", LogFields{"file": filepath.Join(outputDirectory, finalFile)}, "| Removed incomplete file", finalFile)
+		}
 	}
+}
+
+func (dataSetPreparationInformation *DataSetPreparationInformation) Stages() []string {
+	stages := make([]string, 0)
 
-	for _, file := range zipReader.File {
-		if file.FileInfo().IsDir() {
-			directory := filepath.Join(uncompressedDirectory, file.Name)
-			if !strings.HasPrefix(directory, uncompressedDirectory) {
-				panic("Not finished successfully.")
+	if !dataSetPreparationInformation.OnlySpecificPreparation {
+		for _, input := range dataSetPreparationInformation.inputs() {
+			stages = append(stages, StageDownload+":"+input.relativePath)
+			if archiveFormat, _ := archiveFormatByExtension(input.relativePath); archiveFormat != nil {
+				stages = append(stages, StageUncompress+":"+input.relativePath)
 			}
-			os.MkdirAll(directory, 600)
-		} else {
-			filePath := filepath.Join(uncompressedDirectory, file.Name)
-			if !strings.HasPrefix(filePath, uncompressedDirectory) {
-				panic("Not finished successfully.")
+		}
+	}
+
+	if dataSetPreparationInformation.Preparation != nil {
+		stages = append(stages, dataSetPreparationInformation.processingStage())
+	}
+
+	return stages
+}
+
+func (dataSetPreparationInformation *DataSetPreparationInformation) processingStage() string {
+	if dataSetPreparationInformation.Name == "" {
+		return StageProcessing
+	}
+	return StageProcessing + ":" + dataSetPreparationInformation.Name
+}
+
+func (dataSetPreparationInformation *DataSetPreparationInformation) inputs() []preparationInput {
+	inputs := make([]preparationInput, 0, len(dataSetPreparationInformation.InputDownloadURLs))
+
+	for _, inputDownloadURL := range dataSetPreparationInformation.InputDownloadURLs {
+		url := dataSetPreparationInformation.PrefixOfInputDownloadURLs + inputDownloadURL
+		relativePath := inputDownloadURL
+		if strings.HasPrefix(inputDownloadURL, "https://") ||
+			strings.HasPrefix(inputDownloadURL, "http://") ||
+			strings.HasPrefix(inputDownloadURL, "ftp://") ||
+			strings.HasPrefix(inputDownloadURL, "file://") {
+			url = inputDownloadURL
+			relativePath = path.Base(url)
+		} else if filepath.IsAbs(inputDownloadURL) {
+			url = inputDownloadURL
+			relativePath = filepath.Base(url)
+		}
+
+		localPath := ""
+		mirrorPrefix := ""
+		for prefix, directory := range dataSetPreparationInformation.Options.Mirrors {
+			if strings.HasPrefix(url, prefix) && len(prefix) > len(mirrorPrefix) {
+				mirrorPrefix = prefix
+				localPath = filepath.Join(directory, filepath.FromSlash(strings.TrimPrefix(url, prefix)))
 			}
-			_, err := os.Stat(filepath.Dir(filePath))
-			if os.IsNotExist(err) {
-				os.MkdirAll(filepath.Dir(filePath), 600)
+		}
+		if localPath == "" {
+			if strings.HasPrefix(url, "file://") {
+				localPath = localPathFromFileURL(url)
+			} else if !strings.Contains(url, "://") {
+				localPath = url
 			}
+		}
 
-			uncompressedFile, err := os.Create(filePath)
-			if err != nil {
-				panic("Not finished successfully.")
+		checksum := ""
+		for _, checksums := range []map[string]string{dataSetPreparationInformation.InputChecksums, dataSetPreparationInformation.Options.Checksums} {
+			if checksums[inputDownloadURL] != "" {
+				checksum = checksums[inputDownloadURL]
+			} else if checksums[url] != "" {
+				checksum = checksums[url]
 			}
+		}
 
-			opennedFile, err := file.Open()
-			if err != nil {
-				panic("Not finished successfully.")
-			}
+		inputs = append(inputs, preparationInput{inputDownloadURL: inputDownloadURL, url: url, relativePath: relativePath, localPath: localPath, checksum: checksum})
+	}
 
-			fileSize, err := io.Copy(uncompressedFile, opennedFile)
-			if err != nil {
-				panic("Not finished successfully.")
-			}
-			if fileSize != file.FileInfo().Size() {
-				panic("Not finished successfully.")
-			}
+	return inputs
+}
 
-			uncompressedFile.Close()
+// A mismatching file is removed so that a resumed run downloads it again.
+func verifyAndRecordInput(manifest *Manifest, input preparationInput, outputDirectory string, filePath string, knownEntry *ManifestEntry) (*ManifestEntry, error) {
+	var err error
+	entry := knownEntry
+	if entry == nil {
+		if input.checksum != "" {
+			Log.Info("verifying_checksum", LogFields{"url": input.url, "file": filePath}, "Verifying checksum...", filepath.Base(filePath))
+		}
+		newEntry, err := newManifestEntry(input.url, filePath)
+		if err != nil {
+			return nil, &PreparationError{Stage: StageDownload, URL: input.url, File: filePath, Err: err}
+		}
+		entry = &newEntry
+	}
+	entry.URL = input.url
+	entry.File = manifestFilePath(outputDirectory, filePath)
+
+	if input.checksum != "" {
+		err = entry.verify(input.checksum)
+		if err != nil {
+			removeErr := os.Remove(filePath)
+			if removeErr != nil && !os.IsNotExist(removeErr) {
+				err = fmt.Errorf("%w (removing the file also failed: %v)", err, removeErr)
+			}
+			return nil, &PreparationError{Stage: StageDownload, URL: input.url, File: filePath, Err: err}
 		}
+		Log.Info("checksum_verified", LogFields{"url": input.url, "file": filePath, "checksum": input.checksum}, "| Checksum verified", filepath.Base(filePath))
 	}
 
+	return entry, manifest.record(*entry, outputDirectory)
 }
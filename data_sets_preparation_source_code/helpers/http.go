@@ -0,0 +1,221 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type httpDownloader struct {
+	client *http.Client
+}
+
+func newHTTPDownloader() *httpDownloader {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = time.Minute
+
+	return &httpDownloader{client: &http.Client{Transport: transport}}
+}
+
+func (downloader *httpDownloader) attempt(ctx context.Context, url string, partFilePath string, progress *downloadProgress) (fileSize int64, retryable bool, err error) {
+	offset, err := partFileSize(partFilePath)
+	if err != nil {
+		return 0, false, err
+	}
+
+	validator := ""
+	if offset > 0 {
+		content, err := ioutil.ReadFile(partFileValidatorPath(partFilePath))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, false, err
+		}
+		validator = string(content)
+		if validator == "" {
+			Log.Warning("resume_not_possible", LogFields{"url": url, "offset": offset}, "| Partial file has no ETag or Last-Modified date to check the server file against, restarting download from the beginning", url)
+			offset = 0
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	if offset > 0 {
+		request.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		request.Header.Set("If-Range", validator)
+	}
+
+	response, err := downloader.client.Do(request)
+	if err != nil {
+		return 0, isRetryableNetworkError(err), err
+	}
+	defer response.Body.Close()
+
+	var totalSize int64 = -1
+	switch {
+	case response.StatusCode == http.StatusPartialContent:
+		var start int64
+		start, totalSize, err = parseContentRange(response.Header.Get("Content-Range"))
+		if err != nil {
+			return 0, false, err
+		}
+		if start != offset {
+			return 0, true, discardPartFile(partFilePath, fmt.Errorf("server resumed at byte %d instead of byte %d", start, offset))
+		}
+	case response.StatusCode == http.StatusOK:
+		if offset > 0 {
+			Log.Warning("resume_not_supported", LogFields{"url": url, "offset": offset}, "| Server does not support resuming or the file changed, restarting download from the beginning", url)
+		}
+		offset = 0
+		totalSize = response.ContentLength
+		err = writePartFileValidator(partFilePath, response)
+		if err != nil {
+			return 0, false, err
+		}
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		_, totalSize, err = parseContentRange(response.Header.Get("Content-Range"))
+		if err == nil && totalSize == offset {
+			return offset, false, nil
+		}
+		return 0, true, discardPartFile(partFilePath, fmt.Errorf("partial file of %d bytes cannot be resumed", offset))
+	case response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusRequestTimeout:
+		return 0, true, fmt.Errorf("unexpected HTTP status %s", response.Status)
+	default:
+		return 0, false, fmt.Errorf("unexpected HTTP status %s", response.Status)
+	}
+
+	if offset > 0 && totalSize >= 0 {
+		Log.Info("download_resumed", LogFields{"url": url, "offset": offset, "size": totalSize}, "| Resuming", url, "at", offset/1024, "KB of", totalSize/1024, "KB")
+	}
+
+	fileSize, retryable, err = writePartFile(partFilePath, offset, totalSize, response.Body, progress, url)
+	if err == nil {
+		os.Remove(partFileValidatorPath(partFilePath))
+	}
+	return fileSize, retryable, err
+}
+
+// The strong ETag or else the Last-Modified date of the response a part file is written from is stored next to it, so that resuming sends it as If-Range and gets the whole file again if it changed.
+func partFileValidatorPath(partFilePath string) string {
+	return partFilePath + ".validator"
+}
+
+func writePartFileValidator(partFilePath string, response *http.Response) error {
+	validator := response.Header.Get("ETag")
+	if strings.HasPrefix(validator, "W/") {
+		validator = ""
+	}
+	if validator == "" {
+		validator = response.Header.Get("Last-Modified")
+	}
+
+	if validator == "" {
+		err := os.Remove(partFileValidatorPath(partFilePath))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(partFileValidatorPath(partFilePath), []byte(validator), FileMode())
+}
+
+// Falls back to a range request of the first byte for servers not answering HEAD with a length.
+func (downloader *httpDownloader) size(ctx context.Context, url string) (int64, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return -1, err
+	}
+	response, err := downloader.client.Do(request)
+	if err != nil {
+		return -1, err
+	}
+	response.Body.Close()
+	if response.StatusCode == http.StatusOK && response.ContentLength >= 0 {
+		return response.ContentLength, nil
+	} else if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone {
+		return -1, fmt.Errorf("unexpected HTTP status %s", response.Status)
+	}
+
+	request, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return -1, err
+	}
+	request.Header.Set("Range", "bytes=0-0")
+	response, err = downloader.client.Do(request)
+	if err != nil {
+		return -1, err
+	}
+	response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		_, totalSize, err := parseContentRange(response.Header.Get("Content-Range"))
+		if err != nil {
+			return -1, err
+		}
+		return totalSize, nil
+	case http.StatusOK:
+		return response.ContentLength, nil
+	default:
+		return -1, fmt.Errorf("unexpected HTTP status %s", response.Status)
+	}
+}
+
+func parseContentRange(contentRange string) (start int64, totalSize int64, err error) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, -1, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+
+	rangeAndTotalSize := strings.SplitN(strings.TrimPrefix(contentRange, "bytes "), "/", 2)
+	if len(rangeAndTotalSize) != 2 {
+		return 0, -1, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+
+	totalSize = -1
+	if rangeAndTotalSize[1] != "*" {
+		totalSize, err = strconv.ParseInt(rangeAndTotalSize[1], 10, 64)
+		if err != nil {
+			return 0, -1, fmt.Errorf("invalid Content-Range %q", contentRange)
+		}
+	}
+
+	if rangeAndTotalSize[0] == "*" {
+		return 0, totalSize, nil
+	}
+
+	start, err = strconv.ParseInt(strings.SplitN(rangeAndTotalSize[0], "-", 2)[0], 10, 64)
+	if err != nil {
+		return 0, -1, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+
+	return start, totalSize, nil
+}
+
+func isRetryableNetworkError(err error) bool {
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		err = urlError.Err
+	}
+
+	var networkError net.Error
+	if errors.As(err, &networkError) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
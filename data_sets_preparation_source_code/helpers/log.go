@@ -0,0 +1,218 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LogLevelDebug   = "debug"
+	LogLevelInfo    = "info"
+	LogLevelWarning = "warning"
+	LogLevelError   = "error"
+)
+
+var logLevelRanks = map[string]int{
+	LogLevelDebug:   0,
+	LogLevelInfo:    1,
+	LogLevelWarning: 2,
+	LogLevelError:   3,
+}
+
+// Written into the output directory, one JSON object per line.
+const RunLogFileName = "preparation_log.jsonl"
+
+// Events of the run log. Statistics are all "statistic" events.
+const (
+	EventRunStarted  = "run_started"
+	EventRunFinished = "run_finished"
+	EventMessage     = "message"
+	EventStatistic   = "statistic"
+)
+
+type LogFields map[string]interface{}
+
+// A line of the run log.
+type LogEvent struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Event   string    `json:"event"`
+	Message string    `json:"message,omitempty"`
+	Fields  LogFields `json:"fields,omitempty"`
+}
+
+// Prints the messages at or above its level and writes every event to the run log. A nil Logger logs nothing.
+type Logger struct {
+	mutex   sync.Mutex
+	level   string
+	console io.Writer
+	runLog  *os.File
+	report  *PreparationReport
+}
+
+// The logger of the preparations. Its run log is open while a preparation runs.
+var Log = &Logger{level: LogLevelInfo, console: os.Stdout}
+
+func isLogLevel(level string) bool {
+	_, isKnown := logLevelRanks[level]
+	return isKnown
+}
+
+func (logger *Logger) SetLevel(level string) error {
+	if level == "" {
+		level = LogLevelInfo
+	}
+	if !isLogLevel(level) {
+		return fmt.Errorf("unknown log level %q (expected %s, %s, %s or %s)", level, LogLevelDebug, LogLevelInfo, LogLevelWarning, LogLevelError)
+	}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.level = level
+	return nil
+}
+
+func (logger *Logger) currentLevel() string {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	return logger.level
+}
+
+// The console is os.Stdout by default.
+func (logger *Logger) SetConsole(console io.Writer) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.console = console
+}
+
+func (logger *Logger) openRunLog(outputDirectory string, report *PreparationReport) error {
+	runLog, err := os.OpenFile(filepath.Join(outputDirectory, RunLogFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, FileMode())
+	if err != nil {
+		return err
+	}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if logger.runLog != nil {
+		logger.runLog.Close()
+	}
+	logger.runLog = runLog
+	logger.report = report
+	return nil
+}
+
+func (logger *Logger) closeRunLog() error {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.report = nil
+	if logger.runLog == nil {
+		return nil
+	}
+	err := logger.runLog.Close()
+	logger.runLog = nil
+	return err
+}
+
+func (logger *Logger) log(level string, event string, fields LogFields, a ...interface{}) {
+	if logger == nil {
+		return
+	}
+	message := strings.TrimSuffix(fmt.Sprintln(a...), "\n")
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	if logLevelRanks[level] >= logLevelRanks[logger.level] && len(a) != 0 {
+		fmt.Fprintln(logger.console, message)
+	}
+	logger.record(LogEvent{Time: time.Now(), Level: level, Event: event, Message: strings.TrimSpace(message), Fields: fields})
+}
+
+// The mutex must be held.
+func (logger *Logger) record(logEvent LogEvent) {
+	if logger.runLog == nil {
+		return
+	}
+
+	line, err := json.Marshal(logEvent)
+	if err != nil {
+		textFields := make(LogFields, len(logEvent.Fields))
+		for name, value := range logEvent.Fields {
+			textFields[name] = jsonText(value)
+		}
+		logEvent.Fields = textFields
+		line, err = json.Marshal(logEvent)
+		if err != nil {
+			return
+		}
+	}
+	logger.runLog.Write(append(line, '\n'))
+}
+
+// Values JSON does not have (such as NaN) are written as text.
+func jsonText(value interface{}) interface{} {
+	_, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return value
+}
+
+func (logger *Logger) Debug(event string, fields LogFields, a ...interface{}) {
+	logger.log(LogLevelDebug, event, fields, a...)
+}
+
+func (logger *Logger) Info(event string, fields LogFields, a ...interface{}) {
+	logger.log(LogLevelInfo, event, fields, a...)
+}
+
+func (logger *Logger) Warning(event string, fields LogFields, a ...interface{}) {
+	logger.log(LogLevelWarning, event, fields, a...)
+}
+
+func (logger *Logger) Error(event string, fields LogFields, a ...interface{}) {
+	logger.log(LogLevelError, event, fields, a...)
+}
+
+// Prints a statistic at the info level (unless a is empty) and records it in the run log and the report.
+func (logger *Logger) Statistic(name string, value interface{}, fields LogFields, a ...interface{}) {
+	if logger == nil {
+		return
+	}
+	logger.mutex.Lock()
+	report := logger.report
+	logger.mutex.Unlock()
+	report.addStatistic(name, value, fields)
+
+	statisticFields := LogFields{"name": name, "value": value}
+	for fieldName, fieldValue := range fields {
+		statisticFields[fieldName] = fieldValue
+	}
+	logger.log(LogLevelInfo, EventStatistic, statisticFields, a...)
+}
+
+// An empty line on the console between groups of messages, not written to the run log.
+func (logger *Logger) EmptyLine() {
+	if logger == nil {
+		return
+	}
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if logLevelRanks[LogLevelInfo] >= logLevelRanks[logger.level] {
+		fmt.Fprintln(logger.console)
+	}
+}
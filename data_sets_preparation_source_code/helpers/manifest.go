@@ -0,0 +1,178 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const manifestFileName = "manifest.json"
+
+type ManifestEntry struct {
+	URL    string `json:"url"`
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5"`
+}
+
+type Manifest struct {
+	Inputs []ManifestEntry `json:"inputs"`
+	mutex  sync.Mutex
+}
+
+func LoadManifest(outputDirectory string) (*Manifest, error) {
+	manifest := &Manifest{Inputs: []ManifestEntry{}}
+	manifestFile := filepath.Join(outputDirectory, manifestFileName)
+
+	content, err := ioutil.ReadFile(manifestFile)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, &PreparationError{Stage: StageSetup, File: manifestFile, Err: err}
+	}
+
+	err = json.Unmarshal(content, manifest)
+	if err != nil {
+		return nil, &PreparationError{Stage: StageSetup, File: manifestFile, Err: err}
+	}
+
+	return manifest, nil
+}
+
+func (manifest *Manifest) Entry(file string) *ManifestEntry {
+	manifest.mutex.Lock()
+	defer manifest.mutex.Unlock()
+
+	for _, entry := range manifest.Inputs {
+		if entry.File == file {
+			return &entry
+		}
+	}
+	return nil
+}
+
+func (manifest *Manifest) record(entry ManifestEntry, outputDirectory string) error {
+	manifest.mutex.Lock()
+	defer manifest.mutex.Unlock()
+
+	isExistingEntry := false
+	for i := range manifest.Inputs {
+		if manifest.Inputs[i].File == entry.File {
+			manifest.Inputs[i] = entry
+			isExistingEntry = true
+		}
+	}
+	if !isExistingEntry {
+		manifest.Inputs = append(manifest.Inputs, entry)
+	}
+
+	return manifest.save(outputDirectory)
+}
+
+func (manifest *Manifest) save(outputDirectory string) error {
+	manifestFile := filepath.Join(outputDirectory, manifestFileName)
+
+	content, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return &PreparationError{Stage: StageWritingFile, File: manifestFile, Err: err}
+	}
+
+	err = writeFileAtomically(manifestFile, content)
+	if err != nil {
+		return &PreparationError{Stage: StageWritingFile, File: manifestFile, Err: err}
+	}
+
+	return nil
+}
+
+func newManifestEntry(url string, filePath string) (ManifestEntry, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	defer file.Close()
+
+	sha256Hash := sha256.New()
+	md5Hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(sha256Hash, md5Hash), file)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+
+	return ManifestEntry{
+		URL:    url,
+		File:   filePath,
+		Size:   size,
+		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
+		MD5:    hex.EncodeToString(md5Hash.Sum(nil)),
+	}, nil
+}
+
+func manifestFilePath(outputDirectory string, filePath string) string {
+	relativeFilePath, err := filepath.Rel(outputDirectory, filePath)
+	if err != nil {
+		return filepath.ToSlash(filePath)
+	}
+	return filepath.ToSlash(relativeFilePath)
+}
+
+// "sha256:<hex>", "md5:<hex>", or a bare digest whose length identifies the algorithm.
+func parseChecksum(checksum string) (algorithm string, digest string, err error) {
+	algorithmAndDigest := strings.SplitN(strings.TrimSpace(checksum), ":", 2)
+	if len(algorithmAndDigest) == 2 {
+		algorithm = strings.ToLower(algorithmAndDigest[0])
+		digest = strings.ToLower(algorithmAndDigest[1])
+	} else {
+		digest = strings.ToLower(algorithmAndDigest[0])
+		if len(digest) == sha256.Size*2 {
+			algorithm = "sha256"
+		} else if len(digest) == md5.Size*2 {
+			algorithm = "md5"
+		}
+	}
+
+	_, err = hex.DecodeString(digest)
+	if err != nil ||
+		(algorithm == "sha256" && len(digest) != sha256.Size*2) ||
+		(algorithm == "md5" && len(digest) != md5.Size*2) ||
+		(algorithm != "sha256" && algorithm != "md5") {
+		return "", "", fmt.Errorf("invalid checksum %q (expected sha256:<hex> or md5:<hex>)", checksum)
+	}
+
+	return algorithm, digest, nil
+}
+
+func (entry ManifestEntry) verify(checksum string) error {
+	algorithm, digest, err := parseChecksum(checksum)
+	if err != nil {
+		return err
+	}
+
+	actualDigest := entry.SHA256
+	if algorithm == "md5" {
+		actualDigest = entry.MD5
+	}
+
+	if actualDigest != digest {
+		return fmt.Errorf("%s checksum mismatch: expected %s but got %s", algorithm, digest, actualDigest)
+	}
+
+	return nil
+}
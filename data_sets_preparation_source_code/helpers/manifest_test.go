@@ -0,0 +1,152 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+	sha256Digest := strings.Repeat("0123456789abcdef", 4)
+	md5Digest := strings.Repeat("0123456789abcdef", 2)
+
+	tests := []struct {
+		name              string
+		checksum          string
+		expectedAlgorithm string
+		expectedDigest    string
+		expectError       bool
+	}{
+		{name: "sha256", checksum: "sha256:" + sha256Digest, expectedAlgorithm: "sha256", expectedDigest: sha256Digest},
+		{name: "md5", checksum: "md5:" + md5Digest, expectedAlgorithm: "md5", expectedDigest: md5Digest},
+		{name: "bare sha256", checksum: sha256Digest, expectedAlgorithm: "sha256", expectedDigest: sha256Digest},
+		{name: "bare md5", checksum: md5Digest, expectedAlgorithm: "md5", expectedDigest: md5Digest},
+		{name: "upper case and spaces", checksum: " SHA256:" + strings.ToUpper(sha256Digest) + "\n", expectedAlgorithm: "sha256", expectedDigest: sha256Digest},
+		{name: "empty", checksum: "", expectError: true},
+		{name: "not hex", checksum: "md5:" + strings.Repeat("g", 32), expectError: true},
+		{name: "md5 of the length of sha256", checksum: "md5:" + sha256Digest, expectError: true},
+		{name: "sha256 of the length of md5", checksum: "sha256:" + md5Digest, expectError: true},
+		{name: "bare digest of another length", checksum: sha256Digest[:40], expectError: true},
+		{name: "unknown algorithm", checksum: "sha1:" + sha256Digest[:40], expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			algorithm, digest, err := parseChecksum(test.checksum)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected an error, got %s %s", algorithm, digest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if algorithm != test.expectedAlgorithm || digest != test.expectedDigest {
+				t.Fatalf("parseChecksum = %s %s, expected %s %s", algorithm, digest, test.expectedAlgorithm, test.expectedDigest)
+			}
+		})
+	}
+}
+
+func TestManifestEntryVerify(t *testing.T) {
+	sha256Sum := sha256.Sum256([]byte("content"))
+	md5Sum := md5.Sum([]byte("content"))
+	otherSha256Sum := sha256.Sum256([]byte("other content"))
+	otherMd5Sum := md5.Sum([]byte("other content"))
+	entry := ManifestEntry{SHA256: hex.EncodeToString(sha256Sum[:]), MD5: hex.EncodeToString(md5Sum[:])}
+
+	tests := []struct {
+		name        string
+		checksum    string
+		expectError bool
+	}{
+		{name: "matching sha256", checksum: "sha256:" + hex.EncodeToString(sha256Sum[:])},
+		{name: "matching md5", checksum: "md5:" + strings.ToUpper(hex.EncodeToString(md5Sum[:]))},
+		{name: "matching bare md5", checksum: hex.EncodeToString(md5Sum[:])},
+		{name: "mismatching sha256", checksum: "sha256:" + hex.EncodeToString(otherSha256Sum[:]), expectError: true},
+		{name: "mismatching md5", checksum: "md5:" + hex.EncodeToString(otherMd5Sum[:]), expectError: true},
+		{name: "md5 given as sha256", checksum: "sha256:" + hex.EncodeToString(md5Sum[:]), expectError: true},
+		{name: "invalid", checksum: "md5:content", expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := entry.verify(test.checksum)
+			if test.expectError != (err != nil) {
+				t.Fatalf("verify(%q) = %v, expected an error: %v", test.checksum, err, test.expectError)
+			}
+		})
+	}
+}
+
+func TestRegisteredInputChecksumsAreVerified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write(testContent(4096))
+	}))
+	defer server.Close()
+
+	sha256Sum := sha256.Sum256(testContent(4096))
+	md5Sum := md5.Sum(testContent(4096))
+	RegisterPreparation(RegisteredPreparation{Name: "manifest_test_matching", InputChecksums: map[string]string{"data.bin": "sha256:" + hex.EncodeToString(sha256Sum[:])}})
+	RegisterPreparation(RegisteredPreparation{Name: "manifest_test_mismatching", InputChecksums: map[string]string{"data.bin": "md5:" + strings.Repeat("0", 32)}})
+
+	tests := []struct {
+		name            string
+		preparationName string
+		optionChecksums map[string]string
+		expectError     bool
+	}{
+		{name: "matching", preparationName: "manifest_test_matching"},
+		{name: "mismatching", preparationName: "manifest_test_mismatching", expectError: true},
+		{name: "overridden by the options", preparationName: "manifest_test_mismatching", optionChecksums: map[string]string{server.URL + "/data.bin": hex.EncodeToString(md5Sum[:])}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outputDirectory := filepath.Join(t.TempDir(), "output")
+			dataSetPreparationInformation := &DataSetPreparationInformation{
+				Name:                      test.preparationName,
+				PrefixOfInputDownloadURLs: server.URL + "/",
+				InputDownloadURLs:         []string{"data.bin"},
+				Preparation:               plannedTestPreparation{},
+				Options:                   PreparationOptions{Checksums: test.optionChecksums},
+			}
+			err := dataSetPreparationInformation.Prepare(context.Background(), outputDirectory)
+			if test.expectError {
+				var preparationError *PreparationError
+				if !errors.As(err, &preparationError) || preparationError.Stage != StageDownload || !strings.Contains(err.Error(), "checksum mismatch") {
+					t.Fatalf("expected a checksum mismatch, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			manifest, err := LoadManifest(outputDirectory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry := manifest.Entry("Downloaded_files/data.bin")
+			if entry == nil || entry.Size != 4096 || entry.SHA256 != hex.EncodeToString(sha256Sum[:]) {
+				t.Fatalf("manifest entry = %+v, expected the size and sha256 of data.bin", entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+// Directories and executable files are written with 0777 and the other files with 0666, less the umask (the process umask still applies).
+const DefaultUmask = "022"
+
+var umask uint32 = 022
+
+// Parses an octal umask such as 022 or 0077.
+func ParseUmask(text string) (os.FileMode, error) {
+	value, err := strconv.ParseUint(text, 8, 32)
+	if err != nil || value&^0777 != 0 {
+		return 0, fmt.Errorf("%q is not an octal umask between 000 and 777", text)
+	}
+	return os.FileMode(value), nil
+}
+
+// Sets the umask of the written directories and files. Prepare sets it from its options while it runs.
+func SetUmask(text string) error {
+	if text == "" {
+		text = DefaultUmask
+	}
+	value, err := ParseUmask(text)
+	if err != nil {
+		return err
+	}
+	setUmask(value)
+	return nil
+}
+
+func setUmask(value os.FileMode) {
+	atomic.StoreUint32(&umask, uint32(value))
+}
+
+func currentUmask() os.FileMode {
+	return os.FileMode(atomic.LoadUint32(&umask))
+}
+
+func DirectoryMode() os.FileMode {
+	return 0777 &^ currentUmask()
+}
+
+func FileMode() os.FileMode {
+	return 0666 &^ currentUmask()
+}
+
+func ExecutableFileMode() os.FileMode {
+	return 0777 &^ currentUmask()
+}
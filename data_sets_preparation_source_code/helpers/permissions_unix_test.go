@@ -0,0 +1,106 @@
+//go:build linux || darwin || freebsd
+
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"archive/tar"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// Writes a table into Final_files, as the preparations do.
+type tablePreparation struct{}
+
+func (tablePreparation) Prepare(ctx context.Context, dataSetPreparationInformation *DataSetPreparationInformation, outputDirectory string) error {
+	return ioutil.WriteFile(filepath.Join(outputDirectory, "Final_files", "table.csv"), []byte("0,1\r\n"), FileMode())
+}
+
+func TestOutputPermissionsFollowTheUmask(t *testing.T) {
+	// The umask of the process applies on top of the one of the options, so it is cleared for the modes to be exact.
+	processUmask := syscall.Umask(0)
+	defer syscall.Umask(processUmask)
+	defer SetUmask(DefaultUmask)
+
+	archivePath := filepath.Join(t.TempDir(), "data.tar.gz")
+	writeTestTarGz(t, archivePath, []testArchiveEntry{
+		{name: "data/", typeflag: tar.TypeDir, mode: 0777},
+		{name: "data/values.txt", content: "1 2 3\n", mode: 0666},
+		{name: "data/tool", content: "#!/bin/sh\n", mode: 0755},
+		{name: "data/setuid_tool", content: "#!/bin/sh\n", mode: 04777},
+	})
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(archivePath))))
+	defer server.Close()
+
+	tests := []struct {
+		umask          string
+		directoryMode  os.FileMode
+		fileMode       os.FileMode
+		executableMode os.FileMode
+	}{
+		{umask: "022", directoryMode: 0755, fileMode: 0644, executableMode: 0755},
+		{umask: "077", directoryMode: 0700, fileMode: 0600, executableMode: 0700},
+	}
+
+	for _, test := range tests {
+		t.Run(test.umask, func(t *testing.T) {
+			outputDirectory := filepath.Join(t.TempDir(), "output")
+			dataSetPreparationInformation := &DataSetPreparationInformation{
+				Name:                      "permissions_test",
+				PrefixOfInputDownloadURLs: server.URL + "/",
+				InputDownloadURLs:         []string{"data.tar.gz"},
+				Preparation:               tablePreparation{},
+				Options:                   PreparationOptions{Umask: test.umask, ResolvedConfig: []byte("{}\n")},
+			}
+			err := dataSetPreparationInformation.Prepare(context.Background(), outputDirectory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if currentUmask() != 022 {
+				t.Errorf("the umask %03o of the preparation is left after it", currentUmask())
+			}
+
+			numberOfFiles := map[string]int{}
+			err = filepath.Walk(outputDirectory, func(filePath string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				expectedMode := test.fileMode
+				switch {
+				case info.IsDir():
+					expectedMode = test.directoryMode
+				case strings.HasSuffix(filePath, "tool"):
+					expectedMode = test.executableMode
+				}
+				if info.Mode().Perm() != expectedMode || info.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != 0 {
+					t.Errorf("%s has mode %v, expected %v", filePath, info.Mode(), expectedMode)
+				}
+				numberOfFiles[filepath.Base(filePath)]++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range []string{"Final_files", "Uncompressed_downloaded_files", "data.tar.gz", "values.txt", "tool", "setuid_tool", "table.csv", ResolvedConfigFileName, RunLogFileName} {
+				if numberOfFiles[name] == 0 {
+					t.Errorf("no %s in the output directory", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// What the processing stage of a preparation runs and writes, shown by dry runs.
+type PreparationPlan struct {
+	ExternalTools []string
+	FinalFiles    []string
+	// Bytes written by the processing stage, 0 if not known.
+	EstimatedSize int64
+}
+
+// Preparations can implement PlannedPreparation to describe their processing stage in dry runs.
+type PlannedPreparation interface {
+	Plan(dataSetPreparationInformation *DataSetPreparationInformation, outputDirectory string) PreparationPlan
+}
+
+// Logs the stages a run would go through without writing anything, failing if the estimated disk space needed is not available.
+func (dataSetPreparationInformation *DataSetPreparationInformation) printPlan(ctx context.Context, outputDirectory string, inputs []preparationInput, state *preparationState) error {
+	options := dataSetPreparationInformation.Options
+
+	isCompleted := func(stage string) bool {
+		for _, forcedStage := range options.ForcedStages {
+			if forcedStage == stage {
+				return false
+			}
+		}
+		return options.Resume && state.isCompleted(stage)
+	}
+
+	var cache *DownloadCache
+	if options.CacheDirectory != "" {
+		cache = &DownloadCache{Directory: options.CacheDirectory}
+	}
+
+	Log.Info("plan_started", LogFields{"preparation": dataSetPreparationInformation.Name, "output_directory": outputDirectory}, "Dry run of", dataSetPreparationInformation.Name, "| Nothing is downloaded or written")
+	Log.Info(EventMessage, nil, "| Output directory:", outputDirectory)
+
+	var neededSize int64 = 0
+	numberOfUnknownSizes := 0
+
+	if !dataSetPreparationInformation.OnlySpecificPreparation {
+		for _, input := range inputs {
+			filePath := filepath.Join(outputDirectory, "Downloaded_files", input.relativePath)
+			var size int64 = -1
+			var sizeErr error
+			action := ""
+
+			if isCompleted(StageDownload + ":" + input.relativePath) {
+				action = "already downloaded"
+				fileInfo, err := os.Stat(filePath)
+				if err == nil {
+					size = fileInfo.Size()
+				}
+			} else if input.localPath != "" {
+				localFiles := options.LocalFiles
+				if localFiles == "" {
+					localFiles = LocalFilesHardLink
+				}
+				action = "local file (" + localFiles + ")"
+				fileInfo, err := os.Stat(input.localPath)
+				if err != nil {
+					sizeErr = err
+				} else {
+					size = fileInfo.Size()
+					if localFiles == LocalFilesCopy {
+						neededSize += size
+					}
+				}
+			} else if cacheEntry := cache.entryOf(input.url); cacheEntry != nil {
+				action = "cached"
+				size = cacheEntry.Size
+			} else {
+				action = "download"
+				size, sizeErr = remoteFileSize(ctx, input.url)
+				if size >= 0 {
+					remainingSize := size
+					if options.Resume {
+						partFilePath := filepath.Join(outputDirectory, "Temporary_files", "Partial_downloads", input.relativePath+".part")
+						partSize, err := partFileSize(partFilePath)
+						if err == nil && partSize <= size {
+							remainingSize -= partSize
+						}
+					}
+					neededSize += remainingSize
+				}
+			}
+
+			inputFields := LogFields{"url": input.url, "file": filepath.Join("Downloaded_files", input.relativePath), "action": action, "size": size}
+			if size >= 0 {
+				Log.Info("plan_input", inputFields, "| Input:", input.url, "->", filepath.Join("Downloaded_files", input.relativePath), "|", action, "| Size~= ", size/1024, "KB")
+			} else {
+				numberOfUnknownSizes++
+				if sizeErr == nil {
+					sizeErr = fmt.Errorf("size not given by the server")
+				}
+				inputFields["error"] = sizeErr.Error()
+				Log.Info("plan_input", inputFields, "| Input:", input.url, "->", filepath.Join("Downloaded_files", input.relativePath), "|", action, "| Size unknown:", sizeErr)
+			}
+
+			archiveFormat, archiveExtension := archiveFormatByExtension(input.relativePath)
+			if archiveFormat == nil {
+				continue
+			}
+			uncompressedDirectory := filepath.Join("Uncompressed_downloaded_files", filepath.Dir(input.relativePath), strings.TrimSuffix(filepath.Base(input.relativePath), archiveExtension))
+			archiveFields := LogFields{"file": input.relativePath, "format": archiveFormat.Name, "directory": uncompressedDirectory}
+			if isCompleted(StageUncompress + ":" + input.relativePath) {
+				archiveFields["action"] = "already uncompressed"
+				Log.Info("plan_archive", archiveFields, "| Archive:", input.relativePath, "(", archiveFormat.Name, ") ->", uncompressedDirectory, "| already uncompressed")
+			} else if size >= 0 {
+				ratio := archiveFormat.UncompressedSizeRatio
+				if ratio == 0 {
+					ratio = 1
+				}
+				estimatedSize := int64(float64(size) * ratio)
+				neededSize += estimatedSize
+				archiveFields["action"] = "uncompress"
+				archiveFields["estimated_size"] = estimatedSize
+				Log.Info("plan_archive", archiveFields, "| Archive:", input.relativePath, "(", archiveFormat.Name, ") ->", uncompressedDirectory, "| Uncompressed size~= ", estimatedSize/1024, "KB (rough estimate)")
+			} else {
+				numberOfUnknownSizes++
+				archiveFields["action"] = "uncompress"
+				archiveFields["estimated_size"] = -1
+				Log.Info("plan_archive", archiveFields, "| Archive:", input.relativePath, "(", archiveFormat.Name, ") ->", uncompressedDirectory, "| Uncompressed size unknown")
+			}
+		}
+	}
+
+	if dataSetPreparationInformation.Preparation != nil {
+		processingStage := dataSetPreparationInformation.processingStage()
+		if isCompleted(processingStage) {
+			Log.Info("plan_processing", LogFields{"stage": processingStage, "completed": true}, "| Processing:", processingStage, "| already completed")
+		} else {
+			Log.Info("plan_processing", LogFields{"stage": processingStage, "completed": false}, "| Processing:", processingStage)
+		}
+
+		if plannedPreparation, ok := dataSetPreparationInformation.Preparation.(PlannedPreparation); ok {
+			plan := plannedPreparation.Plan(dataSetPreparationInformation, outputDirectory)
+			for _, externalTool := range plan.ExternalTools {
+				Log.Info("plan_external_tool", LogFields{"tool": externalTool}, "| External tool:", externalTool)
+			}
+			for _, finalFile := range append(plan.FinalFiles, filepath.Join("Final_files", ReportFileName)) {
+				Log.Info("plan_final_file", LogFields{"file": finalFile}, "| Final file:", finalFile)
+			}
+			if !isCompleted(processingStage) {
+				neededSize += plan.EstimatedSize
+			}
+		}
+	}
+
+	// The output directory may not exist yet, so the disk space is that of its nearest existing parent.
+	existingDirectory := outputDirectory
+	for {
+		_, err := os.Stat(existingDirectory)
+		if err == nil || filepath.Dir(existingDirectory) == existingDirectory {
+			break
+		}
+		existingDirectory = filepath.Dir(existingDirectory)
+	}
+	availableSize, err := availableDiskSpace(existingDirectory)
+
+	unknownSizesNote := ""
+	if numberOfUnknownSizes != 0 {
+		unknownSizesNote = fmt.Sprint("(not counting ", numberOfUnknownSizes, " unknown sizes)")
+	}
+	diskSpaceFields := LogFields{"needed_size": neededSize, "number_of_unknown_sizes": numberOfUnknownSizes, "directory": existingDirectory}
+	if err != nil {
+		diskSpaceFields["error"] = err.Error()
+		Log.Info("plan_disk_space", diskSpaceFields, "| Disk space needed~= ", neededSize/1024, "KB", unknownSizesNote, "| Available disk space unknown:", err)
+		return nil
+	}
+	diskSpaceFields["available_size"] = availableSize
+	Log.Info("plan_disk_space", diskSpaceFields, "| Disk space needed~= ", neededSize/1024, "KB", unknownSizesNote, "| Available~= ", availableSize/1024, "KB")
+
+	if neededSize > availableSize {
+		return &PreparationError{Stage: StageSetup, File: existingDirectory, Err: fmt.Errorf("not enough disk space: about %d KB needed but %d KB available", neededSize/1024, availableSize/1024)}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type plannedTestPreparation struct{}
+
+func (plannedTestPreparation) Plan(dataSetPreparationInformation *DataSetPreparationInformation, outputDirectory string) PreparationPlan {
+	return PreparationPlan{ExternalTools: []string{"tool"}, FinalFiles: []string{filepath.Join("Final_files", "table.csv")}}
+}
+
+func (plannedTestPreparation) Prepare(ctx context.Context, dataSetPreparationInformation *DataSetPreparationInformation, outputDirectory string) error {
+	return nil
+}
+
+func TestDryRunLogsThePlan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Length", "4096")
+		writer.Write(testContent(4096))
+	}))
+	defer server.Close()
+
+	console := bytes.Buffer{}
+	Log.SetConsole(&console)
+	defer Log.SetConsole(ioutil.Discard)
+
+	outputDirectory := filepath.Join(t.TempDir(), "output")
+	dataSetPreparationInformation := &DataSetPreparationInformation{
+		Name:                      "plan_test",
+		PrefixOfInputDownloadURLs: server.URL + "/",
+		InputDownloadURLs:         []string{"data.tar.gz"},
+		Preparation:               plannedTestPreparation{},
+		Options:                   PreparationOptions{DryRun: true},
+	}
+	err := dataSetPreparationInformation.Prepare(context.Background(), outputDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expectedLine := range []string{
+		"Dry run of plan_test | Nothing is downloaded or written",
+		"| Input: " + server.URL + "/data.tar.gz -> " + filepath.Join("Downloaded_files", "data.tar.gz") + " | download | Size~=  4 KB",
+		"| Archive: data.tar.gz ( tar.gz ) -> " + filepath.Join("Uncompressed_downloaded_files", "data") + " | Uncompressed size~= ",
+		"| Processing: processing:plan_test",
+		"| External tool: tool",
+		"| Final file: " + filepath.Join("Final_files", "table.csv"),
+		"| Final file: " + filepath.Join("Final_files", ReportFileName),
+		"| Disk space needed~= ",
+	} {
+		if !strings.Contains(console.String(), expectedLine) {
+			t.Errorf("no %q in the plan:\n%s", expectedLine, console.String())
+		}
+	}
+	if _, err := os.Stat(outputDirectory); !os.IsNotExist(err) {
+		t.Errorf("the dry run created the output directory: %v", err)
+	}
+}
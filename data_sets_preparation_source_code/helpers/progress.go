@@ -0,0 +1,182 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaximumConcurrentDownloads = 3
+	DownloadProgressInterval          = 5 * time.Second
+)
+
+type downloadProgress struct {
+	mutex            sync.Mutex
+	files            map[string]*fileDownloadProgress
+	fileOrder        []string
+	transferredBytes int64
+	startedAt        time.Time
+	stopped          chan struct{}
+	waitGroup        sync.WaitGroup
+}
+
+type fileDownloadProgress struct {
+	size       int64
+	downloaded int64
+	isFinished bool
+}
+
+type progressWriter struct {
+	progress *downloadProgress
+	url      string
+}
+
+func newDownloadProgress() *downloadProgress {
+	return &downloadProgress{files: make(map[string]*fileDownloadProgress), fileOrder: make([]string, 0)}
+}
+
+func (progress *downloadProgress) addFile(url string) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.fileProgress(url)
+}
+
+func (progress *downloadProgress) fileProgress(url string) *fileDownloadProgress {
+	file, ok := progress.files[url]
+	if !ok {
+		file = &fileDownloadProgress{size: -1}
+		progress.files[url] = file
+		progress.fileOrder = append(progress.fileOrder, url)
+	}
+	return file
+}
+
+// A size of -1 means the size of the file is not known.
+func (progress *downloadProgress) startFile(url string, offset int64, size int64) {
+	if progress == nil {
+		return
+	}
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	file := progress.fileProgress(url)
+	file.downloaded = offset
+	file.size = size
+}
+
+func (progress *downloadProgress) add(url string, numberOfBytes int64) {
+	if progress == nil {
+		return
+	}
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.fileProgress(url).downloaded += numberOfBytes
+	progress.transferredBytes += numberOfBytes
+}
+
+func (progress *downloadProgress) finishFile(url string, size int64) {
+	if progress == nil {
+		return
+	}
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	file := progress.fileProgress(url)
+	file.size = size
+	file.downloaded = size
+	file.isFinished = true
+}
+
+func (progress *downloadProgress) start(interval time.Duration) {
+	progress.startedAt = time.Now()
+	progress.stopped = make(chan struct{})
+	progress.waitGroup.Add(1)
+
+	go func() {
+		defer progress.waitGroup.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				Log.Info("download_progress", nil, progress.String())
+			case <-progress.stopped:
+				return
+			}
+		}
+	}()
+}
+
+func (progress *downloadProgress) stop() {
+	close(progress.stopped)
+	progress.waitGroup.Wait()
+
+	progress.mutex.Lock()
+	numberOfFiles := len(progress.files)
+	progress.mutex.Unlock()
+	if numberOfFiles != 0 {
+		Log.Info("download_progress", nil, progress.String())
+		Log.EmptyLine()
+	}
+}
+
+func (progress *downloadProgress) String() string {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	var downloadedBytes, totalBytes int64 = 0, 0
+	numberOfFinishedFiles := 0
+	isTotalKnown := true
+	for _, url := range progress.fileOrder {
+		file := progress.files[url]
+		downloadedBytes += file.downloaded
+		if file.size >= 0 {
+			totalBytes += file.size
+		} else {
+			isTotalKnown = false
+		}
+		if file.isFinished {
+			numberOfFinishedFiles++
+		}
+	}
+
+	elapsed := time.Since(progress.startedAt)
+	var rate float64 = 0
+	if elapsed > 0 {
+		rate = float64(progress.transferredBytes) / elapsed.Seconds()
+	}
+
+	text := strings.Builder{}
+	text.WriteString("| Downloading | Files: ")
+	text.WriteString(strconv.Itoa(numberOfFinishedFiles) + " / " + strconv.Itoa(len(progress.fileOrder)))
+	text.WriteString(" | Size~= " + strconv.FormatInt(downloadedBytes/1024, 10))
+	if isTotalKnown {
+		text.WriteString(" / " + strconv.FormatInt(totalBytes/1024, 10))
+	} else {
+		text.WriteString(" / ?")
+	}
+	text.WriteString(" KB | Rate~= " + strconv.FormatFloat(rate/1024, 'f', 0, 64) + " KB/s")
+	if isTotalKnown && rate > 0 && numberOfFinishedFiles != len(progress.fileOrder) {
+		remaining := time.Duration(float64(totalBytes-downloadedBytes) / rate * float64(time.Second))
+		text.WriteString(" | ETA~= " + remaining.Round(time.Second).String())
+	}
+
+	return text.String()
+}
+
+func (writer progressWriter) Write(buffer []byte) (int, error) {
+	writer.progress.add(writer.url, int64(len(buffer)))
+	return len(buffer), nil
+}
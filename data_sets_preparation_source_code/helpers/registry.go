@@ -0,0 +1,221 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ParameterTypeString = "string"
+	ParameterTypeList   = "list"
+	ParameterTypeInt    = "int"
+)
+
+// A named parameter of a registered preparation. The default of a list parameter is comma separated.
+type PreparationParameter struct {
+	Name        string
+	Description string
+	Type        string
+	Default     string
+	Required    bool
+}
+
+// The values of the parameters by name: the items of a list parameter, or the single value of another parameter.
+type PreparationParameters map[string][]string
+
+func (parameters PreparationParameters) String(name string) string {
+	if len(parameters[name]) == 0 {
+		return ""
+	}
+	return parameters[name][0]
+}
+
+func (parameters PreparationParameters) Strings(name string) []string {
+	return parameters[name]
+}
+
+// The value of an int parameter, which Resolve has checked to be an integer.
+func (parameters PreparationParameters) Int(name string) int {
+	value, _ := strconv.Atoi(parameters.String(name))
+	return value
+}
+
+// What a registered preparation is run with, after the defaults of the preparation are filled in by Resolve.
+type PreparationRequest struct {
+	OutputDirectory           string
+	PrefixOfInputDownloadURLs string
+	InputDownloadURLs         []string
+	Parameters                PreparationParameters
+	Options                   PreparationOptions
+}
+
+// A data set preparation that can be run by name, registered in an init function of its package.
+//
+// An empty DefaultPrefixOfInputDownloadURLs makes the prefix required, NumberOfInputDownloadURLs of 0 allows any number of inputs, and NoInputs preparations work on the output of an earlier one.
+// InputChecksums are the expected checksums of inputs by input download URL (as for -checksum), which the checksums of the options override.
+type RegisteredPreparation struct {
+	Name        string
+	Description string
+
+	DefaultPrefixOfInputDownloadURLs string
+	DefaultInputDownloadURLs         []string
+	FixedInputDownloadURLs           bool
+	NumberOfInputDownloadURLs        int
+	NoInputs                         bool
+	InputChecksums                   map[string]string
+
+	Parameters []PreparationParameter
+	Prepare    func(ctx context.Context, request *PreparationRequest) error
+}
+
+var registeredPreparationsMutex sync.Mutex
+var registeredPreparations = make(map[string]RegisteredPreparation)
+
+func RegisterPreparation(preparation RegisteredPreparation) {
+	registeredPreparationsMutex.Lock()
+	defer registeredPreparationsMutex.Unlock()
+
+	if _, isRegistered := registeredPreparations[preparation.Name]; isRegistered {
+		panic("preparation " + preparation.Name + " is registered twice")
+	}
+	registeredPreparations[preparation.Name] = preparation
+}
+
+func RegisteredPreparations() []RegisteredPreparation {
+	registeredPreparationsMutex.Lock()
+	defer registeredPreparationsMutex.Unlock()
+
+	preparations := make([]RegisteredPreparation, 0, len(registeredPreparations))
+	for _, preparation := range registeredPreparations {
+		preparations = append(preparations, preparation)
+	}
+	sort.Slice(preparations, func(i, j int) bool {
+		return preparations[i].Name < preparations[j].Name
+	})
+	return preparations
+}
+
+func LookupPreparation(name string) (*RegisteredPreparation, error) {
+	registeredPreparationsMutex.Lock()
+	defer registeredPreparationsMutex.Unlock()
+
+	preparation, isRegistered := registeredPreparations[name]
+	if !isRegistered {
+		names := make([]string, 0, len(registeredPreparations))
+		for registeredName := range registeredPreparations {
+			names = append(names, registeredName)
+		}
+		sort.Strings(names)
+		return nil, &PreparationError{Stage: StageArguments, Err: fmt.Errorf("unknown preparation %q (available: %s)", name, strings.Join(names, ", "))}
+	}
+	return &preparation, nil
+}
+
+// The input checksums of the registered preparation of the name, for the preparations that do not set their own.
+func registeredInputChecksums(name string) map[string]string {
+	registeredPreparationsMutex.Lock()
+	defer registeredPreparationsMutex.Unlock()
+
+	return registeredPreparations[name].InputChecksums
+}
+
+func (preparation *RegisteredPreparation) Parameter(name string) *PreparationParameter {
+	for i := range preparation.Parameters {
+		if preparation.Parameters[i].Name == name {
+			return &preparation.Parameters[i]
+		}
+	}
+	return nil
+}
+
+func invalidRequest(preparation *RegisteredPreparation, format string, a ...interface{}) error {
+	return &PreparationError{Stage: StageArguments, Err: fmt.Errorf(preparation.Name+": "+format, a...)}
+}
+
+// Checks the request against the preparation and fills in the default URL prefix, inputs and parameter values.
+func (preparation *RegisteredPreparation) Resolve(request *PreparationRequest) error {
+	if request.OutputDirectory == "" {
+		return invalidRequest(preparation, "output directory is missing")
+	}
+
+	if preparation.NoInputs {
+		if request.PrefixOfInputDownloadURLs != "" || len(request.InputDownloadURLs) != 0 {
+			return invalidRequest(preparation, "the preparation has no inputs, but a URL prefix or inputs are given")
+		}
+	} else {
+		if request.PrefixOfInputDownloadURLs == "" {
+			request.PrefixOfInputDownloadURLs = preparation.DefaultPrefixOfInputDownloadURLs
+		}
+		if request.PrefixOfInputDownloadURLs == "" {
+			return invalidRequest(preparation, "URL prefix is missing")
+		}
+
+		if len(request.InputDownloadURLs) == 0 {
+			request.InputDownloadURLs = append([]string(nil), preparation.DefaultInputDownloadURLs...)
+		} else if preparation.FixedInputDownloadURLs && strings.Join(request.InputDownloadURLs, "\n") != strings.Join(preparation.DefaultInputDownloadURLs, "\n") {
+			return invalidRequest(preparation, "the preparation downloads fixed inputs, only the URL prefix can be changed")
+		}
+		if len(request.InputDownloadURLs) == 0 {
+			return invalidRequest(preparation, "inputs are missing")
+		}
+		if preparation.NumberOfInputDownloadURLs != 0 && len(request.InputDownloadURLs) != preparation.NumberOfInputDownloadURLs {
+			return invalidRequest(preparation, "expected %d input(s) but %d are given", preparation.NumberOfInputDownloadURLs, len(request.InputDownloadURLs))
+		}
+		for _, inputDownloadURL := range request.InputDownloadURLs {
+			if inputDownloadURL == "" {
+				return invalidRequest(preparation, "an input is empty")
+			}
+		}
+	}
+
+	if request.Parameters == nil {
+		request.Parameters = make(PreparationParameters)
+	}
+	for name := range request.Parameters {
+		if preparation.Parameter(name) == nil {
+			return invalidRequest(preparation, "unknown parameter %q", name)
+		}
+	}
+	for _, parameter := range preparation.Parameters {
+		values := request.Parameters[parameter.Name]
+		if parameter.Type != ParameterTypeList && len(values) > 1 {
+			return invalidRequest(preparation, "parameter %q is not a list", parameter.Name)
+		}
+		if len(values) == 0 || parameter.Type != ParameterTypeList && values[0] == "" {
+			values = nil
+			if parameter.Type == ParameterTypeList && parameter.Default != "" {
+				values = strings.Split(parameter.Default, ",")
+			} else if parameter.Default != "" {
+				values = []string{parameter.Default}
+			}
+		}
+		if len(values) == 0 && parameter.Required {
+			return invalidRequest(preparation, "parameter %q is missing", parameter.Name)
+		}
+		for _, value := range values {
+			if value == "" {
+				return invalidRequest(preparation, "parameter %q has an empty item in %q", parameter.Name, values)
+			}
+			if parameter.Type == ParameterTypeInt {
+				if _, err := strconv.Atoi(value); err != nil {
+					return invalidRequest(preparation, "parameter %q is not an integer: %q", parameter.Name, value)
+				}
+			}
+		}
+		request.Parameters[parameter.Name] = values
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Written into Final_files, with an entry per preparation run in the output directory.
+const ReportFileName = "report.json"
+
+type ReportStage struct {
+	Stage     string    `json:"stage"`
+	Skipped   bool      `json:"skipped"`
+	StartedAt time.Time `json:"started_at"`
+	Seconds   float64   `json:"seconds"`
+}
+
+type ReportStatistic struct {
+	Name   string      `json:"name"`
+	Value  interface{} `json:"value"`
+	Fields LogFields   `json:"fields,omitempty"`
+}
+
+// Statistics of a computation, for the callers of the library functions.
+type Statistics []ReportStatistic
+
+// Adds the statistic and logs it with the Statistic method of the logger, which may be nil.
+func (statistics *Statistics) Add(logger *Logger, name string, value interface{}, fields LogFields, a ...interface{}) {
+	*statistics = append(*statistics, ReportStatistic{Name: name, Value: value, Fields: fields})
+	logger.Statistic(name, value, fields, a...)
+}
+
+// What a preparation was run with and what it produced.
+type PreparationReport struct {
+	Preparation               string                 `json:"preparation"`
+	PrefixOfInputDownloadURLs string                 `json:"url_prefix,omitempty"`
+	InputDownloadURLs         []string               `json:"inputs,omitempty"`
+	Parameters                []string               `json:"parameters,omitempty"`
+	Settings                  map[string]interface{} `json:"settings,omitempty"`
+	InputFiles                []ManifestEntry        `json:"input_files"`
+	StartedAt                 time.Time              `json:"started_at"`
+	FinishedAt                time.Time              `json:"finished_at"`
+	Seconds                   float64                `json:"seconds"`
+	Stages                    []ReportStage          `json:"stages"`
+	Tools                     map[string]string      `json:"tools"`
+	Statistics                []ReportStatistic      `json:"statistics"`
+
+	mutex sync.Mutex
+}
+
+type reportFile struct {
+	Preparations []*PreparationReport `json:"preparations"`
+}
+
+func newPreparationReport(dataSetPreparationInformation *DataSetPreparationInformation) *PreparationReport {
+	report := &PreparationReport{
+		Preparation:               dataSetPreparationInformation.Name,
+		PrefixOfInputDownloadURLs: dataSetPreparationInformation.PrefixOfInputDownloadURLs,
+		InputDownloadURLs:         dataSetPreparationInformation.InputDownloadURLs,
+		Parameters:                dataSetPreparationInformation.Parameters,
+		Settings:                  dataSetPreparationInformation.Settings,
+		InputFiles:                []ManifestEntry{},
+		StartedAt:                 time.Now().UTC(),
+		Stages:                    []ReportStage{},
+		Tools:                     map[string]string{"go": runtime.Version()},
+		Statistics:                []ReportStatistic{},
+	}
+
+	buildInfo, isKnown := debug.ReadBuildInfo()
+	if isKnown {
+		report.Tools[buildInfo.Main.Path] = buildInfo.Main.Version
+		for _, dependency := range buildInfo.Deps {
+			report.Tools[dependency.Path] = dependency.Version
+		}
+	}
+
+	return report
+}
+
+// Records the version of an external tool run by the preparation. The report may be nil.
+func (report *PreparationReport) AddTool(name string, version string) {
+	if report == nil {
+		return
+	}
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+	report.Tools[name] = version
+}
+
+func (report *PreparationReport) addStatistic(name string, value interface{}, fields LogFields) {
+	if report == nil {
+		return
+	}
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+	report.Statistics = append(report.Statistics, ReportStatistic{Name: name, Value: value, Fields: fields})
+}
+
+func (report *PreparationReport) recordStage(stage string, startedAt time.Time, skipped bool) {
+	if report == nil {
+		return
+	}
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+	report.Stages = append(report.Stages, ReportStage{Stage: stage, Skipped: skipped, StartedAt: startedAt.UTC(), Seconds: time.Since(startedAt).Seconds()})
+}
+
+func (report *PreparationReport) save(outputDirectory string) error {
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	manifest, err := LoadManifest(outputDirectory)
+	if err != nil {
+		return err
+	}
+	report.InputFiles = manifest.Inputs
+	report.FinishedAt = time.Now().UTC()
+	report.Seconds = report.FinishedAt.Sub(report.StartedAt).Seconds()
+
+	reportFilePath := filepath.Join(outputDirectory, "Final_files", ReportFileName)
+	existingReports := reportFile{}
+	content, err := ioutil.ReadFile(reportFilePath)
+	if err == nil {
+		err = json.Unmarshal(content, &existingReports)
+		if err != nil {
+			return &PreparationError{Stage: StageWritingFile, File: reportFilePath, Err: err}
+		}
+	} else if !os.IsNotExist(err) {
+		return &PreparationError{Stage: StageWritingFile, File: reportFilePath, Err: err}
+	}
+
+	reports := reportFile{Preparations: []*PreparationReport{}}
+	for _, existingReport := range existingReports.Preparations {
+		if existingReport.Preparation != report.Preparation {
+			reports.Preparations = append(reports.Preparations, existingReport)
+		}
+	}
+	reports.Preparations = append(reports.Preparations, report)
+
+	content, err = json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		// JSON has no NaN, so such values are written as text.
+		for i := range report.Statistics {
+			if _, err := json.Marshal(report.Statistics[i]); err != nil {
+				report.Statistics[i].Value = jsonText(report.Statistics[i].Value)
+				for name, value := range report.Statistics[i].Fields {
+					report.Statistics[i].Fields[name] = jsonText(value)
+				}
+			}
+		}
+		content, err = json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return &PreparationError{Stage: StageWritingFile, File: reportFilePath, Err: err}
+		}
+	}
+
+	err = writeFileAtomically(reportFilePath, append(content, '\n'))
+	if err != nil {
+		return &PreparationError{Stage: StageWritingFile, File: reportFilePath, Err: err}
+	}
+	return nil
+}
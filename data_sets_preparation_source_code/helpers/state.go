@@ -0,0 +1,106 @@
+/*
+	Copyright (c) 2022 Farshad Barahimi. Licensed under the MIT license.
+
+	This file (this code) is written by Farshad Barahimi.
+
+	The purpose of writing this code is academic.
+*/
+
+package helpers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const preparationStateFileName = "preparation_state.json"
+
+type preparationState struct {
+	CompletedStages []string `json:"completed_stages"`
+	filePath        string
+	mutex           sync.Mutex
+}
+
+func loadPreparationState(outputDirectory string) (*preparationState, error) {
+	state := &preparationState{CompletedStages: []string{}, filePath: filepath.Join(outputDirectory, preparationStateFileName)}
+
+	content, err := ioutil.ReadFile(state.filePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, &PreparationError{Stage: StageSetup, File: state.filePath, Err: err}
+	}
+
+	err = json.Unmarshal(content, state)
+	if err != nil {
+		return nil, &PreparationError{Stage: StageSetup, File: state.filePath, Err: err}
+	}
+
+	return state, nil
+}
+
+func (state *preparationState) isCompleted(stage string) bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	for _, completedStage := range state.CompletedStages {
+		if completedStage == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func (state *preparationState) markCompleted(stage string) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	for _, completedStage := range state.CompletedStages {
+		if completedStage == stage {
+			return nil
+		}
+	}
+	state.CompletedStages = append(state.CompletedStages, stage)
+	return state.save()
+}
+
+func (state *preparationState) forget(stage string) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	completedStages := make([]string, 0, len(state.CompletedStages))
+	for _, completedStage := range state.CompletedStages {
+		if completedStage != stage {
+			completedStages = append(completedStages, completedStage)
+		}
+	}
+	state.CompletedStages = completedStages
+	return state.save()
+}
+
+func (state *preparationState) save() error {
+	content, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return &PreparationError{Stage: StageSetup, File: state.filePath, Err: err}
+	}
+
+	err = writeFileAtomically(state.filePath, content)
+	if err != nil {
+		return &PreparationError{Stage: StageSetup, File: state.filePath, Err: err}
+	}
+
+	return nil
+}
+
+func writeFileAtomically(filePath string, content []byte) error {
+	temporaryFilePath := filePath + ".tmp"
+	err := ioutil.WriteFile(temporaryFilePath, content, FileMode())
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryFilePath, filePath)
+}
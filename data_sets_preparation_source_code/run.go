@@ -9,11 +9,20 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/farshad-barahimi-academic-codes/data_sets_preparation/emails_features_1"
-	"github.com/farshad-barahimi-academic-codes/data_sets_preparation/genomes_distances"
+	_ "github.com/farshad-barahimi-academic-codes/data_sets_preparation/emails_features_1"
+	_ "github.com/farshad-barahimi-academic-codes/data_sets_preparation/genomes_distances"
+	"github.com/farshad-barahimi-academic-codes/data_sets_preparation/helpers"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -24,65 +33,334 @@ func main() {
 	fmt.Println("The purpose of writing this code is academic.")
 	fmt.Println()
 
+	// The first Ctrl-C (or SIGTERM) cancels the run, which stops cleanly, and a second one exits at once.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := run(ctx, args)
+	stop()
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "Not finished successfully (cancelled).", err)
+		os.Exit(130)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Not finished successfully.", err)
+		os.Exit(1)
+	}
+}
+
+type command struct {
+	name        string
+	description string
+	run         func(ctx context.Context, programName string, args []string) error
+}
+
+// The registered preparations come first, followed by the commands working on config files and on the cache.
+func commands() []command {
+	commandList := make([]command, 0)
+	for _, preparation := range helpers.RegisteredPreparations() {
+		preparation := preparation
+		commandList = append(commandList, command{preparation.Name, preparation.Description, func(ctx context.Context, programName string, args []string) error {
+			return runPreparationCommand(ctx, programName, preparation, args)
+		}})
+	}
+	return append(commandList,
+		command{"run", "run the preparation described by a YAML or JSON config file", runConfigCommand},
+		command{"cache", "list, verify or prune the shared download cache", runCacheCommand},
+		command{"help", "show the list of commands or the flags of one command", runHelpCommand})
+}
+
+func commandDescription(name string) string {
+	for _, command := range commands() {
+		if command.name == name {
+			return command.description
+		}
+	}
+	return ""
+}
+
+func run(ctx context.Context, args []string) error {
+	programName := filepath.Base(args[0])
+
 	if len(args) < 2 {
-		fmt.Println("Not finished successfully. Incorrect number of arguments.")
-		return
+		printUsage(os.Stderr, programName)
+		return &helpers.PreparationError{Stage: helpers.StageArguments, Err: errors.New("no command given")}
 	}
 
-	dataSetPreparationType := args[1]
+	commandName := args[1]
+	if commandName == "-h" || commandName == "-help" || commandName == "--help" {
+		return runHelpCommand(ctx, programName, nil)
+	}
 
-	if dataSetPreparationType == "emails_features_1" {
-		if len(args) != 6 {
-			fmt.Println("Not finished successfully. Incorrect number of arguments.")
-			return
+	for _, command := range commands() {
+		if command.name == commandName {
+			return command.run(ctx, programName, args[2:])
 		}
-		outputDirectory := args[2]
-		prefixOfInputDownloadURLs := args[3]
-		inputDownloadURLs := args[4]
-		parameters := strings.Split(args[5], ",")
-		emails_features_1.Run(outputDirectory, prefixOfInputDownloadURLs, inputDownloadURLs, parameters)
-	} else if dataSetPreparationType == "genomes_preparation_1" {
-		if len(args) != 3 && len(args) != 4 {
-			fmt.Println("Not finished successfully. Incorrect number of arguments.")
-			return
+	}
+
+	printUsage(os.Stderr, programName)
+	return &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("unknown command %q", commandName)}
+}
+
+func printUsage(output io.Writer, programName string) {
+	fmt.Fprintln(output, "Usage:", programName, "<command> [flags]")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "Commands:")
+	for _, command := range commands() {
+		fmt.Fprintf(output, "  %-37s %s\n", command.name, command.description)
+	}
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "Run \""+programName, "help <command>\" or \""+programName, "<command> -h\" for the flags of a command.")
+}
+
+func runHelpCommand(ctx context.Context, programName string, args []string) error {
+	if len(args) == 0 {
+		printUsage(os.Stdout, programName)
+		return nil
+	}
+	if len(args) > 1 {
+		return unexpectedArguments(args[1:])
+	}
+	for _, command := range commands() {
+		if command.name == args[0] && command.name != "help" {
+			return command.run(ctx, programName, []string{"-h"})
 		}
-		outputDirectory := args[2]
-		if len(args) == 3 {
-			genomes_distances.PrepareGenomes1(outputDirectory, nil)
-		} else {
-			if args[3] == "" {
-				panic("Not finished successfully.")
-			}
-			genomes_distances.PrepareGenomes1(outputDirectory, args[3])
+	}
+	return &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("unknown command %q", args[0])}
+}
+
+// The flag set of every command prints its own usage, and -h is not reported as a failure.
+func newCommandFlagSet(programName string, commandName string, arguments string, description string) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(commandName, flag.ContinueOnError)
+	flagSet.Usage = commandUsage(flagSet, programName, commandName, arguments, description)
+	return flagSet
+}
+
+func commandUsage(flagSet *flag.FlagSet, programName string, commandName string, arguments string, description string) func() {
+	return func() {
+		fmt.Fprintln(flagSet.Output(), "Usage:", programName, commandName, arguments)
+		fmt.Fprintln(flagSet.Output())
+		fmt.Fprintln(flagSet.Output(), strings.ToUpper(description[:1])+description[1:]+".")
+		fmt.Fprintln(flagSet.Output())
+		fmt.Fprintln(flagSet.Output(), "Flags:")
+		flagSet.PrintDefaults()
+	}
+}
+
+func parseCommandFlags(flagSet *flag.FlagSet, args []string) (helpRequested bool, err error) {
+	err = flagSet.Parse(args)
+	if err == flag.ErrHelp {
+		return true, nil
+	} else if err != nil {
+		return false, &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("%s: %w", flagSet.Name(), err)}
+	}
+	if flagSet.NArg() != 0 {
+		return false, unexpectedArguments(flagSet.Args())
+	}
+	return false, nil
+}
+
+func addPreparationFlags(flagSet *flag.FlagSet, outputDirectory *string, options *helpers.PreparationOptions) {
+	flagSet.StringVar(outputDirectory, "output", "", "output directory (required, must not exist unless -resume is given)")
+	flagSet.BoolVar(&options.Resume, "resume", false, "continue a previous run in an existing output directory, skipping completed stages")
+	flagSet.BoolVar(&options.DryRun, "dry-run", false, "only show what would be downloaded, uncompressed, run and written, and check the disk space")
+	flagSet.StringVar(&options.LogLevel, "log-level", helpers.LogLevelInfo, "lowest level of the messages printed: debug, info, warning or error (the run log "+helpers.RunLogFileName+" in the output directory gets all of them)")
+	flagSet.Var((*stringListFlag)(&options.ForcedStages), "force", "redo the named stage (e.g. download:integrated_call_samples_v3.20130502.ALL.panel), can be repeated")
+	flagSet.StringVar(&options.CacheDirectory, "cache-directory", os.Getenv(helpers.CacheDirectoryEnvironmentVariable), "shared download cache directory (default from the "+helpers.CacheDirectoryEnvironmentVariable+" environment variable)")
+	flagSet.IntVar(&options.MaximumConcurrentDownloads, "parallel-downloads", helpers.DefaultMaximumConcurrentDownloads, "maximum number of files downloaded at the same time")
+	options.Checksums = make(map[string]string)
+	options.Mirrors = make(map[string]string)
+	flagSet.Var((*keyValueFlag)(&options.Mirrors), "mirror", "use a local directory instead of a URL prefix as <URL prefix>=<directory>, can be repeated")
+	flagSet.StringVar(&options.LocalFiles, "local-files", helpers.LocalFilesHardLink, "how local input files are placed in Downloaded_files: hardlink (falls back to copy), symlink or copy")
+	flagSet.StringVar(&options.UnsafeArchiveEntries, "unsafe-archive-entries", helpers.UnsafeArchiveEntriesReject, "what to do with archive entries linking or writing outside of the uncompressed directory, or special files: reject or skip")
+	options.MaximumUncompressedSize = helpers.DefaultMaximumUncompressedSize
+	flagSet.Var((*byteSizeFlag)(&options.MaximumUncompressedSize), "max-uncompressed-size", "maximum total size uncompressed from one archive, in bytes or with a K, M, G or T suffix")
+	flagSet.IntVar(&options.MaximumArchiveEntries, "max-archive-entries", helpers.DefaultMaximumArchiveEntries, "maximum number of entries uncompressed from one archive")
+	flagSet.StringVar(&options.Umask, "umask", helpers.DefaultUmask, "octal umask of the written directories and files, e.g. 022 (0755 and 0644) or 077 (0700 and 0600)")
+	flagSet.StringVar(&options.OutputFormat, "output-format", helpers.OutputFormatCSV, "format of the tables written into Final_files: csv or tsv")
+	flagSet.Var((*keyValueFlag)(&options.Checksums), "checksum", "expected checksum of an input as <input URL>=sha256:<hex> or <input URL>=md5:<hex>, can be repeated")
+}
+
+func isFlagSet(flagSet *flag.FlagSet, name string) bool {
+	isSet := false
+	flagSet.Visit(func(setFlag *flag.Flag) {
+		if setFlag.Name == name {
+			isSet = true
 		}
+	})
+	return isSet
+}
 
-	} else if dataSetPreparationType == "genomes_distances_1" {
-		if len(args) != 3 {
-			fmt.Println("Not finished successfully. Incorrect number of arguments.")
-			return
+// Flags of a registered preparation: -url-prefix and -input unless the preparation has no inputs or fixed inputs, and one flag per parameter with underscores written as dashes.
+func runPreparationCommand(ctx context.Context, programName string, preparation helpers.RegisteredPreparation, args []string) error {
+	config := &preparationConfig{Preparation: preparation.Name}
+	options := helpers.PreparationOptions{}
+
+	arguments := []string{"-output <directory>"}
+	flagSet := newCommandFlagSet(programName, preparation.Name, "", preparation.Description)
+	addPreparationFlags(flagSet, &config.OutputDirectory, &options)
+	if !preparation.NoInputs {
+		if preparation.DefaultPrefixOfInputDownloadURLs == "" {
+			arguments = append(arguments, "-url-prefix <URL>")
+			flagSet.StringVar(&config.PrefixOfInputDownloadURLs, "url-prefix", "", "prefix of the input download URLs, or a local directory (required)")
+		} else {
+			arguments = append(arguments, "[-url-prefix <URL>]")
+			flagSet.StringVar(&config.PrefixOfInputDownloadURLs, "url-prefix", "", "prefix of the input download URLs, or a local directory (default "+preparation.DefaultPrefixOfInputDownloadURLs+")")
 		}
-		outputDirectory := args[2]
+	}
+	if !preparation.NoInputs && !preparation.FixedInputDownloadURLs {
+		description := "input relative to -url-prefix or an absolute URL, can be repeated"
+		if preparation.NumberOfInputDownloadURLs == 1 {
+			description = "input relative to -url-prefix or an absolute URL"
+		}
+		if len(preparation.DefaultInputDownloadURLs) == 0 {
+			arguments = append(arguments, "-input <file>")
+			description += " (required)"
+		}
+		flagSet.Var((*stringListFlag)(&config.InputDownloadURLs), "input", description)
+	}
+	parameterValues := make(map[string]*string)
+	for _, parameter := range preparation.Parameters {
+		flagName := strings.ReplaceAll(parameter.Name, "_", "-")
+		description := parameter.Description
+		if parameter.Required {
+			arguments = append(arguments, "-"+flagName+" <"+parameter.Type+">")
+			description += " (required)"
+		}
+		parameterValues[parameter.Name] = flagSet.String(flagName, parameter.Default, description)
+	}
+	flagSet.Usage = commandUsage(flagSet, programName, preparation.Name, strings.Join(arguments, " ")+" [flags]", preparation.Description)
+	helpRequested, err := parseCommandFlags(flagSet, args)
+	if helpRequested || err != nil {
+		return err
+	}
+
+	if isFlagSet(flagSet, "url-prefix") && config.PrefixOfInputDownloadURLs == "" {
+		return emptyPrefixOfInputDownloadURLs()
+	}
+	config.Parameters = make(map[string]interface{})
+	for name, value := range parameterValues {
+		config.Parameters[name] = *value
+	}
+	config.setOptions(options)
+	return runPreparationConfig(ctx, config, options)
+}
+
+// Runs a preparation described by a config file, such as the preparation_config.json of an earlier run.
+func runConfigCommand(ctx context.Context, programName string, args []string) error {
+	flagSet := newCommandFlagSet(programName, "run", "-config <file> [-output <directory>] [flags]", commandDescription("run"))
+	configFilePath := flagSet.String("config", "", "YAML or JSON preparation config file (required)")
+	outputDirectory := flagSet.String("output", "", "output directory, overriding the one in the config file")
+	runOptions := helpers.PreparationOptions{}
+	flagSet.BoolVar(&runOptions.Resume, "resume", false, "continue a previous run in an existing output directory, skipping completed stages")
+	flagSet.BoolVar(&runOptions.DryRun, "dry-run", false, "only show what would be downloaded, uncompressed, run and written, and check the disk space")
+	flagSet.StringVar(&runOptions.LogLevel, "log-level", helpers.LogLevelInfo, "lowest level of the messages printed: debug, info, warning or error (the run log "+helpers.RunLogFileName+" in the output directory gets all of them)")
+	flagSet.Var((*stringListFlag)(&runOptions.ForcedStages), "force", "redo the named stage (e.g. download:integrated_call_samples_v3.20130502.ALL.panel), can be repeated")
+	flagSet.StringVar(&runOptions.CacheDirectory, "cache-directory", os.Getenv(helpers.CacheDirectoryEnvironmentVariable), "shared download cache directory (default from the "+helpers.CacheDirectoryEnvironmentVariable+" environment variable)")
+	helpRequested, err := parseCommandFlags(flagSet, args)
+	if helpRequested || err != nil {
+		return err
+	}
+
+	if *configFilePath == "" {
+		return missingFlag(flagSet, "config")
+	}
+	config, err := loadPreparationConfig(*configFilePath)
+	if err != nil {
+		return err
+	}
+	if *outputDirectory != "" {
+		config.OutputDirectory = *outputDirectory
+	}
+	return runPreparationConfig(ctx, config, runOptions)
+}
+
+func runCacheCommand(ctx context.Context, programName string, args []string) error {
+	cacheCommand := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cacheCommand = args[0]
+		args = args[1:]
+	}
+
+	flagSet := newCommandFlagSet(programName, "cache", "list|verify|prune [flags]", commandDescription("cache"))
+	cacheDirectory := flagSet.String("cache-directory", os.Getenv(helpers.CacheDirectoryEnvironmentVariable), "shared download cache directory (default from the "+helpers.CacheDirectoryEnvironmentVariable+" environment variable)")
+	removeCorrupt := flagSet.Bool("remove-corrupt", false, "verify: remove cached files that fail verification")
+	olderThan := flagSet.Duration("older-than", 0, "prune: also remove entries not used within this duration (e.g. 720h)")
+	dryRun := flagSet.Bool("dry-run", false, "prune: only list what would be removed")
+	helpRequested, err := parseCommandFlags(flagSet, args)
+	if helpRequested || err != nil {
+		return err
+	}
+
+	if cacheCommand == "" {
+		return &helpers.PreparationError{Stage: helpers.StageArguments, Err: errors.New("cache: expected a cache command: list, verify or prune")}
+	}
+	if cacheCommand != "list" && cacheCommand != "verify" && cacheCommand != "prune" {
+		return &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("cache: unknown cache command %q (expected list, verify or prune)", cacheCommand)}
+	}
+	if *cacheDirectory == "" {
+		return &helpers.PreparationError{Stage: helpers.StageArguments, Err: errors.New("cache: no cache directory given with -cache-directory or " + helpers.CacheDirectoryEnvironmentVariable)}
+	}
 
-		genomes_distances.PrepareGenomeDistances1(outputDirectory)
-	} else if dataSetPreparationType == "genomes_preparation_and_distances_1" {
-		if len(args) != 3 && len(args) != 4 {
-			fmt.Println("Not finished successfully. Incorrect number of arguments.")
-			return
+	cache, err := helpers.OpenDownloadCache(*cacheDirectory)
+	if err != nil {
+		return err
+	}
+
+	if cacheCommand == "list" {
+		entries, err := cache.Entries()
+		if err != nil {
+			return err
+		}
+		var totalSize int64 = 0
+		for _, entry := range entries {
+			fmt.Println(entry.URL, "| Size~= ", entry.Size/1024, "KB | sha256:", entry.SHA256, "| Last used:", entry.LastUsedAt.Format(time.UnixDate))
+			totalSize += entry.Size
+		}
+		fmt.Println("Number of cached files:", len(entries), "| Total size~= ", totalSize/1024, "KB")
+	} else if cacheCommand == "verify" {
+		problems, err := cache.Verify(*removeCorrupt)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) != 0 {
+			return &helpers.PreparationError{Stage: "verifying cache", File: *cacheDirectory, Err: fmt.Errorf("%d problems found", len(problems))}
 		}
-		outputDirectory := args[2]
-		if len(args) == 3 {
-			genomes_distances.PrepareGenomes1(outputDirectory, nil)
-			genomes_distances.PrepareGenomeDistances1(outputDirectory)
+		fmt.Println("Cache verified.")
+	} else if cacheCommand == "prune" {
+		removed, err := cache.Prune(*olderThan, *dryRun)
+		if err != nil {
+			return err
+		}
+		if *dryRun {
+			for _, removedItem := range removed {
+				fmt.Println("Would remove", removedItem)
+			}
+			fmt.Println("Number of cache items that would be removed:", len(removed))
 		} else {
-			if args[3] == "" {
-				panic("Not finished successfully.")
+			for _, removedItem := range removed {
+				fmt.Println("Removed", removedItem)
 			}
-			genomes_distances.PrepareGenomes1(outputDirectory, args[3])
-			genomes_distances.PrepareGenomeDistances1(outputDirectory)
+			fmt.Println("Number of removed cache items:", len(removed))
 		}
-
-	} else {
-		fmt.Println("Not finished successfully.")
-		return
 	}
+
+	return nil
+}
+
+func unexpectedArguments(args []string) error {
+	return &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("unexpected arguments %q (all arguments are given with flags)", args)}
+}
+
+func missingFlag(flagSet *flag.FlagSet, name string) error {
+	return &helpers.PreparationError{Stage: helpers.StageArguments, Err: fmt.Errorf("%s: missing required flag -%s (see %s -h)", flagSet.Name(), name, flagSet.Name())}
+}
+
+func emptyPrefixOfInputDownloadURLs() error {
+	return &helpers.PreparationError{Stage: helpers.StageArguments, Err: errors.New("prefix of input download URLs is empty")}
 }